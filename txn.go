@@ -13,9 +13,23 @@ type Txn interface {
 	Rollback(context.Context) error // Rollback the transaction.
 }
 
+type txnKey struct{}
+
+// WithTxn returns a context carrying the transaction handed to a DoFunc.
+func WithTxn(ctx context.Context, txn Txn) context.Context {
+	return context.WithValue(ctx, txnKey{}, txn)
+}
+
+// TxnFrom returns the transaction carried by the context, if any.
+func TxnFrom(ctx context.Context) (Txn, bool) {
+	txn, ok := ctx.Value(txnKey{}).(Txn)
+	return txn, ok
+}
+
 // Doer defines the interface for database transaction operations.
 type Doer[TOptions any, TBeginner any] interface {
 	Mutate(setters ...DoerFieldSetter)
+	Snapshot(setters ...DoerFieldSetter) DoerFields
 	BeginTxn(context.Context, TBeginner) (Txn, error)
 	Title() string
 	Rethrow() bool
@@ -23,21 +37,87 @@ type Doer[TOptions any, TBeginner any] interface {
 	MaxPing() int
 	MaxRetry() int
 	Options() TOptions
+	Report() *Report
+	Observers() []Observer
+}
+
+// Resetter is implemented by Doers holding state that must not carry over between attempts.
+// The retry loop calls ResetAttempt before each attempt of a transaction.
+type Resetter interface {
+	ResetAttempt()
+}
+
+// ResetAttempt resets the Doer if it implements Resetter.
+func ResetAttempt(doer any) {
+	if r, ok := doer.(Resetter); ok {
+		r.ResetAttempt()
+	}
 }
 
 // DoerFields provides data fields for DoerBase struct.
+// A DoerFields value is also used as an immutable per-call snapshot of a Doer's configuration.
 type DoerFields struct {
-	title    string
-	rethrow  bool
-	timeout  time.Duration
-	maxPing  int
-	maxRetry int
-	options  any
+	title     string
+	rethrow   bool
+	timeout   time.Duration
+	maxPing   int
+	maxRetry  int
+	options   any
+	report    *Report
+	observers []Observer
+	settings  map[string]string
+}
+
+// Title gets the title.
+func (f DoerFields) Title() string {
+	return f.title
+}
+
+// Rethrow gets the rethrow panic flag.
+func (f DoerFields) Rethrow() bool {
+	return f.rethrow
+}
+
+// Timeout gets the timeout duration.
+func (f DoerFields) Timeout() time.Duration {
+	return f.timeout
+}
+
+// MaxPing gets the maximum ping count.
+func (f DoerFields) MaxPing() int {
+	return f.maxPing
+}
+
+// MaxRetry gets the maximum retry count.
+func (f DoerFields) MaxRetry() int {
+	return f.maxRetry
+}
+
+// Options gets the options.
+func (f DoerFields) Options() any {
+	return f.options
+}
+
+// Report gets the execution report, nil if none is requested.
+func (f DoerFields) Report() *Report {
+	return f.report
+}
+
+// Observers gets the observers.
+func (f DoerFields) Observers() []Observer {
+	return f.observers
+}
+
+// Settings gets the session settings applied right after the transaction begins, nil if none.
+func (f DoerFields) Settings() map[string]string {
+	return f.settings
 }
 
 // DoerBase provides a base implementation for the Doer interface.
+// Its getters return the Doer's own configuration, which ExecuteRo/ExecuteRw and setters do not change;
+// a DoFunc reads the configuration of its call with FieldsOf(ctx, do).
 type DoerBase[TOptions any, TBeginner any] struct {
-	mutex  sync.Mutex
+	mutex  sync.RWMutex
 	fields DoerFields
 }
 
@@ -50,6 +130,17 @@ func (do *DoerBase[_, _]) Mutate(setters ...DoerFieldSetter) {
 	}
 }
 
+// Snapshot returns a copy of DoerBase's fields with the setters applied, leaving DoerBase unchanged.
+func (do *DoerBase[_, _]) Snapshot(setters ...DoerFieldSetter) DoerFields {
+	do.mutex.RLock()
+	fields := do.fields
+	do.mutex.RUnlock()
+	for _, setter := range setters {
+		setter(&fields)
+	}
+	return fields
+}
+
 // BeginTxn begins a new transaction.
 func (do *DoerBase[_, B]) BeginTxn(context.Context, B) (Txn, error) {
 	return nil, errors.Join(ErrNotImplemented, errors.New("[txn.DoerBase.BeginTxn]"))
@@ -57,32 +148,32 @@ func (do *DoerBase[_, B]) BeginTxn(context.Context, B) (Txn, error) {
 
 // Title gets the title.
 func (do *DoerBase[_, _]) Title() string {
-	return do.fields.title
+	return do.Snapshot().Title()
 }
 
 // Rethrow gets the rethrow panic flag.
 func (do *DoerBase[_, _]) Rethrow() bool {
-	return do.fields.rethrow
+	return do.Snapshot().Rethrow()
 }
 
 // Timeout gets the timeout duration.
 func (do *DoerBase[_, _]) Timeout() time.Duration {
-	return do.fields.timeout
+	return do.Snapshot().Timeout()
 }
 
 // MaxPing gets the maximum ping count.
 func (do *DoerBase[_, _]) MaxPing() int {
-	return do.fields.maxPing
+	return do.Snapshot().MaxPing()
 }
 
 // MaxRetry gets the maximum retry count.
 func (do *DoerBase[_, _]) MaxRetry() int {
-	return do.fields.maxRetry
+	return do.Snapshot().MaxRetry()
 }
 
 // Options gets the options.
 func (do *DoerBase[T, _]) Options() T {
-	if t, ok := do.fields.options.(T); ok {
+	if t, ok := do.Snapshot().Options().(T); ok {
 		return t
 	} else {
 		var empty T
@@ -90,6 +181,51 @@ func (do *DoerBase[T, _]) Options() T {
 	}
 }
 
+// Report gets the execution report, nil if none is requested.
+func (do *DoerBase[_, _]) Report() *Report {
+	return do.Snapshot().Report()
+}
+
+// Observers gets the observers of the Doer.
+func (do *DoerBase[_, _]) Observers() []Observer {
+	return do.Snapshot().Observers()
+}
+
+type fieldsKey struct{}
+
+// WithFields returns a context carrying the per-call snapshot of a Doer's fields.
+func WithFields(ctx context.Context, fields DoerFields) context.Context {
+	return context.WithValue(ctx, fieldsKey{}, fields)
+}
+
+// FieldsFrom returns the per-call snapshot carried by the context, if any.
+func FieldsFrom(ctx context.Context) (DoerFields, bool) {
+	fields, ok := ctx.Value(fieldsKey{}).(DoerFields)
+	return fields, ok
+}
+
+// FieldsOf returns the per-call snapshot carried by the context, or else a snapshot of the Doer.
+func FieldsOf(ctx context.Context, doer interface {
+	Snapshot(...DoerFieldSetter) DoerFields
+}) DoerFields {
+	if fields, ok := FieldsFrom(ctx); ok {
+		return fields
+	}
+	return doer.Snapshot()
+}
+
+// OptionsOf returns the options of the per-call snapshot carried by the context, or else opt.
+// The snapshot takes precedence, so that BeginTxn(ctx, db, do.Options()) honours the options of the call.
+func OptionsOf[T comparable](ctx context.Context, opt T) T {
+	var empty T
+	if fields, ok := FieldsFrom(ctx); ok {
+		if o, ok := fields.Options().(T); ok && o != empty {
+			return o
+		}
+	}
+	return opt
+}
+
 // DoerFieldSetter defines a function signature for setting DoerFields.
 type DoerFieldSetter func(*DoerFields)
 
@@ -135,7 +271,32 @@ func WithOptions(value any) DoerFieldSetter {
 	}
 }
 
+// WithReport creates a field setter for the execution report.
+func WithReport(value *Report) DoerFieldSetter {
+	return func(do *DoerFields) {
+		do.report = value
+	}
+}
+
+// WithObservers creates a field setter for the observers.
+func WithObservers(values ...Observer) DoerFieldSetter {
+	return func(do *DoerFields) {
+		do.observers = values
+	}
+}
+
+// WithSettings creates a field setter for the session settings applied right after the transaction begins,
+// such as statement_timeout, lock_timeout, search_path or custom app.* variables.
+// Backends also derive settings from the Doer, such as statement_timeout from its timeout;
+// txn_sql applies them with the settings applier registered for the driver.
+func WithSettings(value map[string]string) DoerFieldSetter {
+	return func(do *DoerFields) {
+		do.settings = value
+	}
+}
+
 var (
 	ErrNilArgument    = errors.New("nil argument")
 	ErrNotImplemented = errors.New("not implemented")
+	ErrInvalidSetting = errors.New("invalid setting")
 )
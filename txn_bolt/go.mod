@@ -0,0 +1,12 @@
+module github.com/struqt/txn/txn_bolt
+
+go 1.22
+
+require (
+	github.com/struqt/txn v0.1.4
+	go.etcd.io/bbolt v1.3.11
+)
+
+require golang.org/x/sys v0.4.0 // indirect
+
+replace github.com/struqt/txn => ../
@@ -0,0 +1,176 @@
+// Package txn_bolt runs Doers in read-only or writable transactions of an embedded bbolt database.
+//
+// The bucket handles of the options are handed to the DoFunc through its context, see Bucket, rather than
+// carried by the Doer: a handle is only valid within its transaction, while a Doer may be shared
+// by concurrent executions.
+package txn_bolt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/struqt/txn"
+	"go.etcd.io/bbolt"
+)
+
+// TxOptions selects a read-only or writable bbolt transaction, and the buckets handed to the DoFunc.
+type TxOptions struct {
+	Writable bool
+	Buckets  []string // Top-level buckets, created on first use by writable transactions.
+}
+
+type (
+	RawTx    = *bbolt.Tx
+	Beginner = *bbolt.DB
+	Options  = *TxOptions
+)
+
+type RawTxn interface {
+	txn.Txn
+	Raw() RawTx
+}
+
+// Doer defines the interface for bbolt transaction operations.
+type Doer interface {
+	txn.Doer[Options, Beginner]
+	ReadOnlySetters(title string) []txn.DoerFieldSetter
+	ReadWriteSetters(title string) []txn.DoerFieldSetter
+}
+
+// DoerBase provides a base implementation for the Doer interface.
+type DoerBase struct {
+	txn.DoerBase[Options, Beginner]
+}
+
+func (do *DoerBase) ReadOnlySetters(title string) []txn.DoerFieldSetter {
+	return []txn.DoerFieldSetter{
+		txn.WithTitle(fmt.Sprintf("TxnRo`%s", title)),
+		txn.WithRethrow(false),
+		txn.WithTimeout(2 * time.Second),
+		txn.WithMaxRetry(1),
+		txn.WithOptions(&TxOptions{Writable: false}),
+	}
+}
+
+func (do *DoerBase) ReadWriteSetters(title string) []txn.DoerFieldSetter {
+	return []txn.DoerFieldSetter{
+		txn.WithTitle(fmt.Sprintf("TxnRw`%s", title)),
+		txn.WithRethrow(false),
+		txn.WithTimeout(5 * time.Second),
+		txn.WithMaxRetry(2),
+		txn.WithOptions(&TxOptions{Writable: true}),
+	}
+}
+
+// WithBuckets creates a field setter adding buckets to the options set before it.
+func WithBuckets(names ...string) txn.DoerFieldSetter {
+	return func(fields *txn.DoerFields) {
+		var clone TxOptions
+		if opt, ok := fields.Options().(Options); ok && opt != nil {
+			clone = TxOptions{Writable: opt.Writable, Buckets: append([]string(nil), opt.Buckets...)}
+		}
+		clone.Buckets = append(clone.Buckets, names...)
+		txn.WithOptions(&clone)(fields)
+	}
+}
+
+type rawTx struct {
+	raw *bbolt.Tx
+}
+
+func (w *rawTx) Raw() RawTx {
+	return w.raw
+}
+
+// Commit commits a writable transaction, and closes a read-only one.
+func (w *rawTx) Commit(context.Context) error {
+	if w.raw == nil {
+		return errors.New("cancelling Commit, Raw is nil")
+	}
+	if !w.raw.Writable() {
+		return w.raw.Rollback()
+	}
+	return w.raw.Commit()
+}
+
+// Rollback rolls back the transaction.
+func (w *rawTx) Rollback(context.Context) error {
+	if w.raw == nil {
+		return errors.New("cancelling Rollback, Raw is nil")
+	}
+	return w.raw.Rollback()
+}
+
+type bucketsKey struct{}
+
+// Bucket returns the handle of a bucket of the options in the DoFunc's context, valid until the DoFunc returns.
+// It is nil for a bucket missing in a read-only transaction.
+func Bucket(ctx context.Context, name string) *bbolt.Bucket {
+	buckets, _ := ctx.Value(bucketsKey{}).(map[string]*bbolt.Bucket)
+	return buckets[name]
+}
+
+// Tx returns the bbolt transaction of the DoFunc's context.
+func Tx(ctx context.Context) (RawTx, error) {
+	if t, ok := txn.TxnFrom(ctx); ok {
+		if raw, ok := t.(RawTxn); ok && raw.Raw() != nil {
+			return raw.Raw(), nil
+		}
+	}
+	return nil, errors.New("no bbolt transaction on current context")
+}
+
+// Open opens the bbolt database file at path, waiting up to timeout for the file lock held by another process.
+func Open(path string, timeout time.Duration) (Beginner, error) {
+	return bbolt.Open(path, 0600, &bbolt.Options{Timeout: timeout})
+}
+
+// ExecuteOnce executes a bbolt transaction.
+func ExecuteOnce[D txn.Doer[Options, Beginner]](
+	ctx context.Context, db Beginner, do D, fn txn.DoFunc[Options, Beginner, D]) error {
+	if timeout := txn.FieldsOf(ctx, do).Timeout(); timeout > time.Millisecond {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return txn.Execute(ctx, db, do, fn)
+}
+
+// BeginTxn begins a bbolt transaction, writable if the options say so.
+// Waiting for the single writer lock is bounded by the ctx deadline, i.e. the Doer's timeout.
+// The options of the per-call snapshot carried by ctx take precedence over opt.
+func BeginTxn(ctx context.Context, db Beginner, opt Options) (RawTxn, error) {
+	opt = txn.OptionsOf(ctx, opt)
+	if opt == nil || !opt.Writable {
+		raw, err := db.Begin(false)
+		if err != nil {
+			return nil, err
+		}
+		return &rawTx{raw: raw}, nil
+	}
+	type begun struct {
+		raw *bbolt.Tx
+		err error
+	}
+	ch := make(chan begun, 1)
+	go func() {
+		raw, err := db.Begin(true)
+		ch <- begun{raw: raw, err: err}
+	}()
+	select {
+	case b := <-ch:
+		if b.err != nil {
+			return nil, b.err
+		}
+		return &rawTx{raw: b.raw}, nil
+	case <-ctx.Done():
+		go func() {
+			if b := <-ch; b.raw != nil {
+				_ = b.raw.Rollback()
+			}
+		}()
+		return nil, fmt.Errorf("%w [writer lock]", ctx.Err())
+	}
+}
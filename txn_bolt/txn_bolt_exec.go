@@ -0,0 +1,111 @@
+package txn_bolt
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/struqt/txn"
+	"go.etcd.io/bbolt"
+)
+
+type ModuleSetter func(*ModuleBase)
+
+type Module interface {
+	Beginner() Beginner
+}
+
+type ModuleBase struct {
+	mutex    sync.Mutex
+	beginner Beginner
+}
+
+func (b *ModuleBase) Beginner() Beginner {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.beginner
+}
+
+func (b *ModuleBase) Mutate(setters ...ModuleSetter) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, setter := range setters {
+		setter(b)
+	}
+}
+
+func WithBeginner(value Beginner) ModuleSetter {
+	return func(do *ModuleBase) {
+		do.beginner = value
+	}
+}
+
+// bindBuckets wraps the DoFunc, so that Bucket returns the handles of the buckets of the options.
+// The handles are carried by the context of the DoFunc, since the Doer may be shared by concurrent executions.
+func bindBuckets[D Doer](fn txn.DoFunc[Options, Beginner, D]) txn.DoFunc[Options, Beginner, D] {
+	return func(ctx context.Context, do D) error {
+		opt, _ := txn.FieldsOf(ctx, do).Options().(Options)
+		if opt == nil || len(opt.Buckets) == 0 {
+			return fn(ctx, do)
+		}
+		tx, err := Tx(ctx)
+		if err != nil {
+			return err
+		}
+		buckets := make(map[string]*bbolt.Bucket, len(opt.Buckets))
+		for _, name := range opt.Buckets {
+			bucket := tx.Bucket([]byte(name))
+			if bucket == nil && tx.Writable() {
+				if bucket, err = tx.CreateBucket([]byte(name)); err != nil {
+					return fmt.Errorf("%w [bucket %s]", err, name)
+				}
+			}
+			buckets[name] = bucket
+		}
+		return fn(context.WithValue(ctx, bucketsKey{}, buckets), do)
+	}
+}
+
+func title[D Doer](do D) string {
+	if do.Title() != "" {
+		return ""
+	}
+	t := reflect.TypeOf(do)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+// ExecuteRw executes fn in a writable bbolt transaction.
+func ExecuteRw[D Doer](
+	ctx context.Context, mod Module, do D,
+	fn txn.DoFunc[Options, Beginner, D], setters ...txn.DoerFieldSetter,
+) (D, error) {
+	s := append(do.ReadWriteSetters(title(do)), setters...)
+	return Execute(ctx, mod, do, fn, s...)
+}
+
+// ExecuteRo executes fn in a read-only bbolt transaction.
+func ExecuteRo[D Doer](
+	ctx context.Context, mod Module, do D,
+	fn txn.DoFunc[Options, Beginner, D], setters ...txn.DoerFieldSetter,
+) (D, error) {
+	s := append(do.ReadOnlySetters(title(do)), setters...)
+	return Execute(ctx, mod, do, fn, s...)
+}
+
+// Execute executes fn in a bbolt transaction, retrying transient failures.
+// There is no connectivity to probe in an embedded database, so other failures are not retried.
+func Execute[D Doer](
+	ctx context.Context, mod Module, doer D,
+	fn txn.DoFunc[Options, Beginner, D], setters ...txn.DoerFieldSetter,
+) (D, error) {
+	err := txn.Retry(ctx, doer.Snapshot(setters...), doer, txn.Steps{
+		Execute: func(ctx context.Context) error {
+			return ExecuteOnce(ctx, mod.Beginner(), doer, bindBuckets(fn))
+		},
+	})
+	return doer, err
+}
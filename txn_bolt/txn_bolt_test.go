@@ -0,0 +1,169 @@
+package txn_bolt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/struqt/txn"
+	"go.etcd.io/bbolt"
+)
+
+type testDoer struct {
+	DoerBase
+}
+
+func (do *testDoer) BeginTxn(ctx context.Context, db Beginner) (txn.Txn, error) {
+	return BeginTxn(ctx, db, nil)
+}
+
+type panicObserver struct {
+	txn.ObserverBase
+	panics int
+}
+
+func (o *panicObserver) OnPanic(context.Context, txn.Event) { o.panics++ }
+
+func newModule(t *testing.T) *ModuleBase {
+	t.Helper()
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	mod := &ModuleBase{}
+	mod.Mutate(WithBeginner(db))
+	return mod
+}
+
+func put(key, value string) txn.DoFunc[Options, Beginner, *testDoer] {
+	return func(ctx context.Context, do *testDoer) error {
+		return Bucket(ctx, "kv").Put([]byte(key), []byte(value))
+	}
+}
+
+func get(key string, value *string) txn.DoFunc[Options, Beginner, *testDoer] {
+	return func(ctx context.Context, do *testDoer) error {
+		if bucket := Bucket(ctx, "kv"); bucket != nil {
+			*value = string(bucket.Get([]byte(key)))
+		}
+		return nil
+	}
+}
+
+func TestExecute(t *testing.T) {
+	mod := newModule(t)
+	ctx := context.Background()
+	doer := &testDoer{}
+
+	writable := func(w *bool) txn.DoFunc[Options, Beginner, *testDoer] {
+		return func(ctx context.Context, do *testDoer) error {
+			tx, err := Tx(ctx)
+			if err != nil {
+				return err
+			}
+			*w = tx.Writable()
+			return nil
+		}
+	}
+	var rw, ro bool
+	if _, err := ExecuteRw(ctx, mod, doer, writable(&rw)); err != nil || !rw {
+		t.Errorf("Expected a writable transaction, got %v", err)
+	}
+	if _, err := ExecuteRo(ctx, mod, doer, writable(&ro)); err != nil || ro {
+		t.Errorf("Expected a read-only transaction, got %v", err)
+	}
+
+	var missing *bbolt.Bucket
+	_, err := ExecuteRo(ctx, mod, doer, func(ctx context.Context, do *testDoer) error {
+		missing = Bucket(ctx, "kv")
+		return nil
+	}, WithBuckets("kv"))
+	if err != nil || missing != nil {
+		t.Errorf("Expected no bucket created by a read-only transaction, got %v", err)
+	}
+	if _, err = ExecuteRw(ctx, mod, doer, put("k", "v"), WithBuckets("kv")); err != nil {
+		t.Fatalf("Expected the bucket created by a writable transaction, got %v", err)
+	}
+
+	// Concurrent executions sharing the Doer each see the buckets of their own options.
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("b%d", i)
+			_, err := ExecuteRw(ctx, mod, doer, func(ctx context.Context, do *testDoer) error {
+				if Bucket(ctx, "kv") != nil || Bucket(ctx, name) == nil {
+					return fmt.Errorf("unexpected buckets in %s", name)
+				}
+				return nil
+			}, WithBuckets(name))
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	var value string
+	if _, err = ExecuteRo(ctx, mod, doer, get("k", &value), WithBuckets("kv")); err != nil || value != "v" {
+		t.Errorf("Expected k=v, got %q and %v", value, err)
+	}
+}
+
+func TestPanic(t *testing.T) {
+	mod := newModule(t)
+	ctx := context.Background()
+	observer := &panicObserver{}
+	_, err := ExecuteRw(ctx, mod, &testDoer{}, func(ctx context.Context, do *testDoer) error {
+		if err := put("k", "v")(ctx, do); err != nil {
+			return err
+		}
+		panic("boom")
+	}, WithBuckets("kv"), txn.WithObservers(observer))
+	if err == nil || !strings.Contains(err.Error(), "boom") || observer.panics != 1 {
+		t.Fatalf("Expected the panic recovered as an error, got %v", err)
+	}
+	value := "unset"
+	if _, err = ExecuteRo(ctx, mod, &testDoer{}, get("k", &value), WithBuckets("kv")); err != nil || value != "unset" {
+		t.Errorf("Expected the bucket and its write rolled back, got %q and %v", value, err)
+	}
+}
+
+func TestWriterLock(t *testing.T) {
+	mod := newModule(t)
+	ctx := context.Background()
+	held, err := mod.Beginner().Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Now()
+	_, err = ExecuteRw(ctx, mod, &testDoer{}, put("k", "v"), WithBuckets("kv"), txn.WithTimeout(50*time.Millisecond))
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "[writer lock]") {
+		t.Errorf("Expected the wait for the writer lock to time out, got %v", err)
+	}
+	if elapsed := time.Since(t0); elapsed > time.Second {
+		t.Errorf("Expected to give up without retrying, took %v", elapsed)
+	}
+	// Ro transactions do not wait for the writer.
+	if _, err = ExecuteRo(ctx, mod, &testDoer{}, func(context.Context, *testDoer) error { return nil }); err != nil {
+		t.Error(err)
+	}
+	if err = held.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ExecuteRw(ctx, mod, &testDoer{}, put("k", "v"), WithBuckets("kv")); err != nil {
+		t.Errorf("Expected the writer lock released, got %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package txn
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+)
+
+// ConnState describes the connectivity implied by the error of a failed attempt.
+type ConnState int
+
+const (
+	ConnOK      ConnState = iota // The connection is usable, the error is not about connectivity.
+	ConnReset                    // The connection was lost or closed.
+	ConnRefused                  // A connection could not be established.
+	ConnTimeout                  // The connection timed out.
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case ConnOK:
+		return "ok"
+	case ConnReset:
+		return "reset"
+	case ConnRefused:
+		return "refused"
+	case ConnTimeout:
+		return "timeout"
+	default:
+		return "unknown"
+	}
+}
+
+// Probe inspects the error of a failed attempt and reports a ConnState, or ConnOK if it does not recognise it.
+type Probe func(err error) ConnState
+
+// ProbeConn inspects the error of a failed attempt with the given backend probes, then with generic checks
+// for network errors, driver.ErrBadConn and closed connections.
+// An expired or cancelled context is not a network timeout, and reports ConnOK.
+func ProbeConn(err error, probes ...Probe) ConnState {
+	if err == nil {
+		return ConnOK
+	}
+	for _, probe := range probes {
+		if state := probe(err); state != ConnOK {
+			return state
+		}
+	}
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ConnRefused
+	case errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNABORTED),
+		errors.Is(err, syscall.EPIPE),
+		errors.Is(err, net.ErrClosed),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, io.EOF):
+		return ConnReset
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		// The caller's deadline or cancellation, which says nothing about the connection.
+		return ConnOK
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		if ne.Timeout() {
+			return ConnTimeout
+		}
+		return ConnReset
+	}
+	return ConnOK
+}
@@ -0,0 +1,144 @@
+package txn
+
+import (
+	"errors"
+	"time"
+)
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+type retryableError struct {
+	err   error
+	after time.Duration
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// Permanent marks an error returned by a DoFunc as not worth retrying.
+// The retry loop returns it immediately, without pinging or invalidating module resources.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// Retryable marks an error returned by a DoFunc as worth retrying after the given delay.
+// The retry loop retries it without pinging, as long as the maximum retry count allows.
+func Retryable(err error, after time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{err: err, after: after}
+}
+
+// IsPermanent reports whether the error chain contains an error marked by Permanent.
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// RetryAfter reports the delay of the first error marked by Retryable in the error chain.
+func RetryAfter(err error) (time.Duration, bool) {
+	var r *retryableError
+	if errors.As(err, &r) {
+		return r.after, true
+	}
+	return 0, false
+}
+
+// ErrorKind classifies database errors portably across backends.
+// It implements error, so that errors.Is(err, txn.UniqueViolation) matches any mapped *Error of that kind.
+type ErrorKind int
+
+const (
+	UniqueViolation ErrorKind = iota + 1
+	ForeignKeyViolation
+	CheckViolation
+	NotNullViolation
+	SerializationFailure
+	Deadlock
+	LockTimeout
+	StatementTimeout
+)
+
+func (k ErrorKind) Error() string {
+	switch k {
+	case UniqueViolation:
+		return "unique violation"
+	case ForeignKeyViolation:
+		return "foreign key violation"
+	case CheckViolation:
+		return "check violation"
+	case NotNullViolation:
+		return "not null violation"
+	case SerializationFailure:
+		return "serialization failure"
+	case Deadlock:
+		return "deadlock"
+	case LockTimeout:
+		return "lock timeout"
+	case StatementTimeout:
+		return "statement timeout"
+	default:
+		return "unknown database error"
+	}
+}
+
+// Error is a backend-neutral database error, carrying the names involved where they are known.
+type Error struct {
+	Kind       ErrorKind
+	Constraint string
+	Table      string
+	Column     string
+	Err        error
+}
+
+// Error returns the message of the wrapped error, or the kind if there is none.
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return e.Kind.Error()
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Is reports whether the target is the kind of the error.
+func (e *Error) Is(target error) bool {
+	k, ok := target.(ErrorKind)
+	return ok && k == e.Kind
+}
+
+// ErrorMapper maps a driver error found in the chain of err to an *Error, or returns nil.
+// The mapped *Error must wrap err.
+type ErrorMapper func(err error) *Error
+
+// MapError returns the first *Error mapped from err, or err itself if none of the mappers recognise it.
+func MapError(err error, mappers ...ErrorMapper) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	for _, mapper := range mappers {
+		if e = mapper(err); e != nil {
+			return e
+		}
+	}
+	return err
+}
+
+// Transient reports whether err is a mapped *Error worth retrying in a new transaction,
+// i.e. a serialization failure or a deadlock.
+func Transient(err error) bool {
+	return errors.Is(err, SerializationFailure) || errors.Is(err, Deadlock)
+}
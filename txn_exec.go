@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"runtime/debug"
+	"time"
 )
 
 // DoFunc defines the function type for transaction execution.
@@ -21,36 +22,60 @@ func Execute[
 		return fmt.Errorf("%w [txn context done]", ctx.Err())
 	default:
 		var txn Txn
-		if txn, err = doer.BeginTxn(ctx, db); err != nil {
+		fields := FieldsOf(ctx, doer)
+		report := fields.Report()
+		observer := ObserverOf(fields)
+		t0 := time.Now()
+		event := func(err error) Event {
+			return Event{Title: fields.Title(), Attempt: AttemptFrom(ctx), Duration: time.Since(t0), Err: err}
+		}
+		txn, err = doer.BeginTxn(ctx, db)
+		report.Track(PhaseBegin, t0)
+		observer.OnBegin(ctx, event(err))
+		if err != nil {
 			return fmt.Errorf("%w [txn begin]", err)
 		}
+		rollback := func(cause error) error {
+			defer report.Track(PhaseRollback, time.Now())
+			x := txn.Rollback(ctx)
+			observer.OnRollback(ctx, event(cause))
+			return x
+		}
 		defer func() {
 			if p := recover(); p != nil {
-				if doer.Rethrow() {
+				if fields.Rethrow() {
 					panic(p)
 				}
 				err = fmt.Errorf("%v --- debug.Stack --- %s", p, debug.Stack())
-				if x := txn.Rollback(ctx); x != nil {
+				observer.OnPanic(ctx, event(err))
+				if x := rollback(err); x != nil {
 					err = fmt.Errorf("%w [txn recover] %w [rollback]", err, x)
 				} else {
 					err = fmt.Errorf("%w [txn recover]", err)
 				}
 			}
 		}()
-		if err = fn(ctx, doer); err != nil {
-			if x := txn.Rollback(ctx); x != nil {
+		t := time.Now()
+		err = fn(WithTxn(ctx, txn), doer)
+		report.Track(PhaseDo, t)
+		if err != nil {
+			if x := rollback(err); x != nil {
 				return fmt.Errorf("%w [txn do] %w [rollback]", err, x)
 			} else {
 				return fmt.Errorf("%w [txn do]", err)
 			}
 		}
-		if err = txn.Commit(ctx); err != nil {
-			if x := txn.Rollback(ctx); x != nil {
+		t = time.Now()
+		err = txn.Commit(ctx)
+		report.Track(PhaseCommit, t)
+		if err != nil {
+			if x := rollback(err); x != nil {
 				return fmt.Errorf("%w [txn commit] %w [rollback]", err, x)
 			} else {
 				return fmt.Errorf("%w [txn commit]", err)
 			}
 		} else {
+			observer.OnCommit(ctx, event(nil))
 			return nil
 		}
 	}
@@ -40,3 +40,18 @@ func RunTicker(ctx context.Context, interval time.Duration, tickCount int, tick
 	}(&wg)
 	wg.Wait()
 }
+
+// Sleep pauses for the given duration, returning early with the context error if it is done.
+func Sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
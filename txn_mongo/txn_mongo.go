@@ -98,7 +98,8 @@ func (w *rawTx) Rollback(ctx context.Context) error {
 // ExecuteOnce executes a pgx transaction.
 func ExecuteOnce[D txn.Doer[Options, Beginner]](
 	ctx context.Context, beginner Beginner, do D, fn txn.DoFunc[Options, Beginner, D]) error {
-	o := do.Options()
+	fields := txn.FieldsOf(ctx, do)
+	o, _ := fields.Options().(Options)
 	var err error
 	var session mongo.Session
 	if o == nil {
@@ -110,9 +111,9 @@ func ExecuteOnce[D txn.Doer[Options, Beginner]](
 		return err
 	}
 	defer session.EndSession(context.Background())
-	if do.Timeout() > time.Millisecond {
+	if timeout := fields.Timeout(); timeout > time.Millisecond {
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, do.Timeout())
+		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 	c1 := mongo.NewSessionContext(ctx, session)
@@ -127,11 +128,13 @@ func Ping(beginner Beginner, limit int, count txn.PingCount) (int, error) {
 }
 
 // BeginTxn begins a pgx transaction.
+// The options of the per-call snapshot carried by ctx take precedence over opt.
 func BeginTxn(ctx context.Context, _ Beginner, opt Options) (RawTxn, error) {
 	session, ok := ctx.(mongo.SessionContext)
 	if !ok {
 		return nil, errors.New("no mongodb_session on current context")
 	}
+	opt = txn.OptionsOf(ctx, opt)
 	var err error
 	if opt == nil {
 		err = session.StartTransaction()
@@ -0,0 +1,57 @@
+package txn_mongo
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/struqt/txn"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+var duplicateKey = regexp.MustCompile(`collection: (\S+) index: ([^\s\]]+)`)
+
+// MapError maps a mongo server error in the chain of err to a *txn.Error.
+func MapError(err error) *txn.Error {
+	if mongo.IsDuplicateKeyError(err) {
+		e := &txn.Error{Kind: txn.UniqueViolation, Err: err}
+		if m := duplicateKey.FindStringSubmatch(err.Error()); m != nil {
+			if i := strings.IndexByte(m[1], '.'); i >= 0 {
+				e.Table = m[1][i+1:]
+			} else {
+				e.Table = m[1]
+			}
+			e.Constraint = m[2]
+		}
+		return e
+	}
+	var se mongo.ServerError
+	if !errors.As(err, &se) {
+		return nil
+	}
+	switch {
+	case se.HasErrorCode(112): // WriteConflict
+		return &txn.Error{Kind: txn.SerializationFailure, Err: err}
+	case se.HasErrorCode(50): // MaxTimeMSExpired
+		return &txn.Error{Kind: txn.StatementTimeout, Err: err}
+	}
+	return nil
+}
+
+// Probe inspects mongo errors for lost connectivity, including the NetworkError label.
+func Probe(err error) txn.ConnState {
+	var selectErr topology.ServerSelectionError
+	switch {
+	case errors.As(err, &selectErr):
+		return txn.ConnRefused
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return txn.ConnOK
+	case mongo.IsTimeout(err):
+		return txn.ConnTimeout
+	case mongo.IsNetworkError(err), errors.Is(err, mongo.ErrClientDisconnected):
+		return txn.ConnReset
+	}
+	return txn.ConnOK
+}
@@ -2,10 +2,8 @@ package txn_mongo
 
 import (
 	"context"
-	"log/slog"
 	"reflect"
 	"sync"
-	"time"
 
 	"github.com/struqt/txn"
 )
@@ -62,40 +60,17 @@ func execute[T any, D Doer[T]](
 	ctx context.Context, mod Module, doer D,
 	fn txn.DoFunc[Options, Beginner, D], setters ...txn.DoerFieldSetter,
 ) (D, error) {
-	doer.Mutate(setters...)
-	var logger *slog.Logger
-	if v, ok := ctx.Value("logger").(*slog.Logger); ok {
-		logger = v
-	} else {
-		logger = slog.Default()
-	}
-	log := logger.With("T", doer.Title())
-	log.Info("+")
-	var x, err error
-	var pings int
-	var retries = -1
-	t1 := time.Now()
-retry:
-	retries++
-	if retries > doer.MaxRetry() && doer.MaxRetry() > 0 {
-		if err != nil {
-			log.Error(err.Error(), "retries", retries, "pings", pings)
-		}
-		return doer, err
-	}
-	if err = ExecuteOnce(ctx, mod.Beginner(), doer, fn); err == nil {
-		log.Info("+", "duration", time.Now().Sub(t1))
-		return doer, nil
-	}
-	pings, x = Ping(mod.Beginner(), doer.MaxPing(), func(cnt int, i time.Duration) {
-		log.Info("Ping", "retries", retries, "pings", cnt, "interval", i)
+	err := txn.Retry(ctx, doer.Snapshot(setters...), doer, txn.Steps{
+		Execute: func(ctx context.Context) error {
+			return ExecuteOnce(ctx, mod.Beginner(), doer, fn)
+		},
+		Recover: func(_ context.Context, err error) error {
+			return txn.MapError(err, MapError)
+		},
+		Probes: []txn.Probe{Probe},
+		Ping: func(_ error, limit int, count txn.PingCount) (int, error) {
+			return Ping(mod.Beginner(), limit, count)
+		},
 	})
-	connected := x == nil && pings <= 1
-	//if connected && retries > 0 {
-	if connected {
-		log.Error(err.Error(), "retries", retries, "pings", pings)
-		return doer, err
-	}
-	log.Info("", "retries", retries, "pings", pings, "err", err)
-	goto retry
+	return doer, err
 }
@@ -0,0 +1,73 @@
+package txn_mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/struqt/txn"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+func TestMapError(t *testing.T) {
+	duplicate := func(code int, message string) error {
+		return mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: code, Message: message}}}
+	}
+	cases := []struct {
+		err        error
+		kind       txn.ErrorKind
+		table      string
+		constraint string
+	}{
+		{duplicate(11000, `E11000 duplicate key error collection: shop.users index: email_1 dup key: { email: "a" }`),
+			txn.UniqueViolation, "users", "email_1"},
+		{duplicate(11001, `E11001 duplicate key on update collection: users index: _id_`),
+			txn.UniqueViolation, "users", "_id_"},
+		{duplicate(11000, `E11000 duplicate key error`), txn.UniqueViolation, "", ""},
+		{mongo.CommandError{Code: 112, Name: "WriteConflict", Labels: []string{"TransientTransactionError"}},
+			txn.SerializationFailure, "", ""},
+		{fmt.Errorf("%w [txn do]", mongo.CommandError{Code: 50, Name: "MaxTimeMSExpired"}),
+			txn.StatementTimeout, "", ""},
+	}
+	for _, c := range cases {
+		e := MapError(c.err)
+		if e == nil || e.Kind != c.kind || e.Table != c.table || e.Constraint != c.constraint {
+			t.Errorf("Expected %v on %s index %s for %v, got %+v", c.kind, c.table, c.constraint, c.err, e)
+			continue
+		}
+		if !errors.Is(txn.MapError(c.err, MapError), c.kind) {
+			t.Errorf("Expected %v to be matched as %v", c.err, c.kind)
+		}
+	}
+	for _, err := range []error{
+		errors.New("insufficient balance"),
+		mongo.CommandError{Code: 13, Name: "Unauthorized"},
+		mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 121, Message: "Document failed validation"}}},
+	} {
+		if e := MapError(err); e != nil {
+			t.Errorf("Expected %v not to be mapped, got %v", err, e.Kind)
+		}
+	}
+}
+
+func TestProbe(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want txn.ConnState
+	}{
+		{errors.New("insufficient balance"), txn.ConnOK},
+		{mongo.CommandError{Code: 112, Name: "WriteConflict"}, txn.ConnOK},
+		{mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000}}}, txn.ConnOK},
+		{fmt.Errorf("%w [txn do]", context.Canceled), txn.ConnOK},
+		{mongo.CommandError{Labels: []string{"NetworkError"}}, txn.ConnReset},
+		{fmt.Errorf("%w [txn commit]", mongo.ErrClientDisconnected), txn.ConnReset},
+		{mongo.CommandError{Labels: []string{"NetworkError", "NetworkTimeoutError"}}, txn.ConnTimeout},
+		{topology.ServerSelectionError{Wrapped: errors.New("connection refused")}, txn.ConnRefused},
+	} {
+		if got := Probe(c.err); got != c.want {
+			t.Errorf("Expected %v for %v, got %v", c.want, c.err, got)
+		}
+	}
+}
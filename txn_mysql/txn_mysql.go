@@ -0,0 +1,173 @@
+package txn_mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/struqt/txn"
+	"github.com/struqt/txn/txn_sql"
+)
+
+func init() {
+	txn_sql.RegisterSettingsApplier(&mysql.MySQLDriver{}, ApplySettings)
+}
+
+type snapshotKey struct{}
+
+// WithConsistentSnapshot returns a context whose transactions start WITH CONSISTENT SNAPSHOT,
+// taking their InnoDB read view at BEGIN instead of at their first read.
+// The snapshot is consistent only under REPEATABLE READ, the session isolation level by default.
+// The isolation level of the options is not kept, as the restarted transaction runs under the session one.
+func WithConsistentSnapshot(ctx context.Context) context.Context {
+	return context.WithValue(ctx, snapshotKey{}, true)
+}
+
+func consistentSnapshot(ctx context.Context) bool {
+	v, _ := ctx.Value(snapshotKey{}).(bool)
+	return v
+}
+
+// BeginTxn begins a MySQL transaction, READ ONLY if the options say so,
+// and sets innodb_lock_wait_timeout for it with ApplySettings, run by txn_sql.BeginTxn as the driver's applier.
+// On a context from WithConsistentSnapshot, the transaction is restarted WITH CONSISTENT SNAPSHOT.
+// The options of the per-call snapshot carried by ctx take precedence over opt.
+func BeginTxn(ctx context.Context, db txn_sql.Beginner, opt txn_sql.Options) (txn_sql.RawTxn, error) {
+	opt = txn.OptionsOf(ctx, opt)
+	t, err := txn_sql.BeginTxn(ctx, db, opt)
+	if err != nil {
+		return nil, err
+	}
+	if consistentSnapshot(ctx) {
+		// Implicitly commits the empty transaction begun by the driver, whose COMMIT then ends this one.
+		start := "START TRANSACTION WITH CONSISTENT SNAPSHOT"
+		if opt != nil && opt.ReadOnly {
+			start += ", READ ONLY"
+		}
+		if _, err = t.Raw().ExecContext(ctx, start); err != nil {
+			return nil, rollback(ctx, t, fmt.Errorf("%w [snapshot]", err))
+		}
+	}
+	return t, nil
+}
+
+func rollback(ctx context.Context, t txn_sql.RawTxn, err error) error {
+	if x := t.Rollback(ctx); x != nil {
+		return fmt.Errorf("%w %w [rollback]", err, x)
+	}
+	return err
+}
+
+// settingName matches the names of the session variables ApplySettings may set.
+var settingName = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// appliedNames holds the names of the session variables set by ApplySettings so far on a database.
+type appliedNames struct {
+	sync.Mutex
+	names map[string]struct{}
+}
+
+// applied maps every database to its *appliedNames, kept for the lifetime of the process.
+var applied sync.Map
+
+func appliedOf(db txn_sql.Beginner) *appliedNames {
+	if a, ok := applied.Load(db); ok {
+		return a.(*appliedNames)
+	}
+	a, _ := applied.LoadOrStore(db, &appliedNames{names: map[string]struct{}{"innodb_lock_wait_timeout": {}}})
+	return a.(*appliedNames)
+}
+
+// settingValue binds integer values as such, since the server rejects strings for some integer variables.
+func settingValue(value string) any {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return n
+	}
+	return value
+}
+
+// ApplySettings sets the settings of the per-call snapshot as session variables in a single statement.
+// Unless set explicitly, innodb_lock_wait_timeout is derived from the remaining time before the ctx deadline.
+// As session variables outlive the transaction on its pooled connection, every variable set by a previous
+// transaction on db and not set by this one is reset to its global value, so that it does not leak into transactions
+// begun by BeginTxn; connections used outside of it may still see them.
+// Names other than plain identifiers fail with txn.ErrInvalidSetting, and integer values are bound as int64.
+// It is registered as the settings applier of the MySQL driver.
+func ApplySettings(ctx context.Context, db txn_sql.Beginner, tx txn_sql.RawTx, fields txn.DoerFields) error {
+	settings := map[string]any{}
+	applied := appliedOf(db)
+	applied.Lock()
+	for name := range applied.names {
+		settings[name] = nil
+	}
+	applied.Unlock()
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); remaining > 0 {
+			settings["innodb_lock_wait_timeout"] = int64(math.Ceil(remaining.Seconds()))
+		}
+	}
+	for name, value := range fields.Settings() {
+		name = strings.ToLower(name)
+		if !settingName.MatchString(name) {
+			return errors.Join(txn.ErrInvalidSetting, fmt.Errorf("[txn_mysql.ApplySettings %q]", name))
+		}
+		settings[name] = settingValue(value)
+	}
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	assignments := make([]string, 0, len(names))
+	args := make([]any, 0, len(names))
+	for _, name := range names {
+		value := settings[name]
+		if value == nil {
+			assignments = append(assignments, fmt.Sprintf("SESSION %s = DEFAULT", name))
+			continue
+		}
+		assignments = append(assignments, fmt.Sprintf("SESSION %s = ?", name))
+		args = append(args, value)
+	}
+	if _, err := tx.ExecContext(ctx, "SET "+strings.Join(assignments, ", "), args...); err != nil {
+		return fmt.Errorf("%w [settings]", err)
+	}
+	applied.Lock()
+	defer applied.Unlock()
+	for _, name := range names {
+		applied.names[name] = struct{}{}
+	}
+	return nil
+}
+
+// ModuleBase retries lock wait timeouts with a jittered backoff, on top of deadlocks retried by txn_sql,
+// and tells lost connectivity from the go-sql-driver/mysql errors.
+type ModuleBase[Stmt txn_sql.StmtHolder] struct {
+	txn_sql.ModuleBase[Stmt]
+	Backoff time.Duration // Base delay before retrying a lock wait timeout, 10ms by default.
+}
+
+// Probe is used by txn_sql.Execute to tell lost connectivity.
+func (b *ModuleBase[Stmt]) Probe(err error) txn.ConnState {
+	return Probe(err)
+}
+
+func (b *ModuleBase[Stmt]) Invalidate(err error, gen uint64) error {
+	if errors.Is(err, txn.LockTimeout) {
+		backoff := b.Backoff
+		if backoff <= 0 {
+			backoff = 10 * time.Millisecond
+		}
+		return txn.Retryable(err, backoff+time.Duration(rand.Int63n(int64(backoff))))
+	}
+	return b.ModuleBase.InvalidateWith(err, gen, Probe)
+}
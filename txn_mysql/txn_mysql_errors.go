@@ -0,0 +1,47 @@
+package txn_mysql
+
+import (
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/struqt/txn"
+	"github.com/struqt/txn/txn_sql"
+)
+
+func init() {
+	txn_sql.RegisterErrorMapper(MapError)
+}
+
+var errorKinds = map[uint16]txn.ErrorKind{
+	1062: txn.UniqueViolation,     // ER_DUP_ENTRY
+	1586: txn.UniqueViolation,     // ER_DUP_ENTRY_WITH_KEY_NAME
+	1451: txn.ForeignKeyViolation, // ER_ROW_IS_REFERENCED_2
+	1452: txn.ForeignKeyViolation, // ER_NO_REFERENCED_ROW_2
+	3819: txn.CheckViolation,      // ER_CHECK_CONSTRAINT_VIOLATED
+	1048: txn.NotNullViolation,    // ER_BAD_NULL_ERROR
+	1213: txn.Deadlock,            // ER_LOCK_DEADLOCK
+	1205: txn.LockTimeout,         // ER_LOCK_WAIT_TIMEOUT
+	3024: txn.StatementTimeout,    // ER_QUERY_TIMEOUT
+}
+
+// MapError maps a *mysql.MySQLError in the chain of err to a *txn.Error.
+// It is registered with txn_sql.RegisterErrorMapper.
+func MapError(err error) *txn.Error {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return nil
+	}
+	kind, ok := errorKinds[mysqlErr.Number]
+	if !ok {
+		return nil
+	}
+	return &txn.Error{Kind: kind, Err: err}
+}
+
+// Probe inspects go-sql-driver/mysql errors for lost connectivity.
+func Probe(err error) txn.ConnState {
+	if errors.Is(err, mysql.ErrInvalidConn) {
+		return txn.ConnReset
+	}
+	return txn_sql.Probe(err)
+}
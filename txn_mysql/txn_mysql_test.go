@@ -0,0 +1,246 @@
+package txn_mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	sqle "github.com/dolthub/go-mysql-server"
+	"github.com/dolthub/go-mysql-server/memory"
+	"github.com/dolthub/go-mysql-server/server"
+	gms "github.com/dolthub/go-mysql-server/sql"
+	"github.com/dolthub/go-mysql-server/sql/types"
+	"github.com/go-sql-driver/mysql"
+	"github.com/struqt/txn"
+	"github.com/struqt/txn/txn_sql"
+)
+
+type nopStmt struct {
+	closed int
+}
+
+func (s *nopStmt) Close() error {
+	s.closed++
+	return nil
+}
+
+type testModule struct {
+	ModuleBase[*nopStmt]
+}
+
+type testDoer struct {
+	txn_sql.DoerBase[*nopStmt]
+}
+
+func (do *testDoer) BeginTxn(ctx context.Context, db txn_sql.Beginner) (txn.Txn, error) {
+	return BeginTxn(ctx, db, nil)
+}
+
+type doFunc = txn.DoFunc[txn_sql.Options, txn_sql.Beginner, *testDoer]
+
+type retryObserver struct {
+	txn.ObserverBase
+	retries, pings int
+}
+
+func (o *retryObserver) OnRetry(context.Context, txn.Event) { o.retries++ }
+func (o *retryObserver) OnPing(context.Context, txn.Event)  { o.pings++ }
+
+// lockWaitTimeout registers innodb_lock_wait_timeout as MySQL defines it, once for the test binary,
+// since the system variables of the server are process-wide.
+// Unlike MySQL, the server defines it as global only, with a single allowed value.
+var lockWaitTimeout sync.Once
+
+// startServer runs an in-process MySQL-compatible server, and opens a database on it.
+func startServer(t *testing.T) *sql.DB {
+	t.Helper()
+	lockWaitTimeout.Do(func() {
+		gms.SystemVariables.AddSystemVariables([]gms.SystemVariable{&gms.MysqlSystemVariable{
+			Name:    "innodb_lock_wait_timeout",
+			Scope:   gms.GetMysqlScope(gms.SystemVariableScope_Both),
+			Dynamic: true,
+			Type:    types.NewSystemIntType("innodb_lock_wait_timeout", 1, 1073741824, false),
+			Default: int64(50),
+		}})
+	})
+	pro := memory.NewDBProvider(memory.NewDatabase("test"))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := server.Config{Protocol: "tcp", Listener: listener}
+	s, err := server.NewServer(cfg, sqle.NewDefault(pro), gms.NewContext, memory.NewSessionBuilder(pro), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = s.Start() }()
+	t.Cleanup(func() { _ = s.Close() })
+	db, err := sql.Open("mysql", fmt.Sprintf("root@tcp(%s)/test", listener.Addr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func TestMapError(t *testing.T) {
+	cases := []struct {
+		number uint16
+		kind   txn.ErrorKind
+		retry  bool
+	}{
+		{1062, txn.UniqueViolation, false},
+		{1213, txn.Deadlock, true},
+		{1205, txn.LockTimeout, true},
+	}
+	mod := &testModule{}
+	for _, c := range cases {
+		err := txn.MapError(fmt.Errorf("%w [exec]", &mysql.MySQLError{Number: c.number}), txn_sql.MapError)
+		if !errors.Is(err, c.kind) {
+			t.Errorf("Expected %d to map to %v, got %v", c.number, c.kind, err)
+		}
+		_, after := txn.RetryAfter(mod.Invalidate(err, 0))
+		if retry := after || txn.Transient(err); retry != c.retry {
+			t.Errorf("Expected %d retryable=%v, got %v", c.number, c.retry, retry)
+		}
+	}
+}
+
+func TestExecute(t *testing.T) {
+	db := startServer(t)
+	mod := &testModule{}
+	mod.Init(db, func(context.Context, txn_sql.Beginner) (*nopStmt, error) { return &nopStmt{}, nil })
+	ctx := context.Background()
+	exec := func(query string, args ...any) doFunc {
+		return func(ctx context.Context, do *testDoer) error {
+			tx, err := txn_sql.Tx(ctx)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, query, args...)
+			return err
+		}
+	}
+
+	if _, err := txn_sql.ExecuteRw[*nopStmt](ctx, mod, &testDoer{},
+		exec("CREATE TABLE t (id INT PRIMARY KEY, n INT)")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := txn_sql.ExecuteRw[*nopStmt](ctx, mod, &testDoer{}, exec("INSERT INTO t VALUES (1, 1)")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := txn_sql.ExecuteRw[*nopStmt](ctx, mod, &testDoer{}, exec("INSERT INTO t VALUES (1, 2)"))
+	if !errors.Is(err, txn.UniqueViolation) {
+		t.Errorf("Expected a unique violation, got %v", err)
+	}
+
+	// The server parses WITH CONSISTENT SNAPSHOT only on its own, not combined with READ ONLY.
+	var n int64
+	_, err = txn_sql.ExecuteRw[*nopStmt](WithConsistentSnapshot(ctx), mod, &testDoer{},
+		func(ctx context.Context, do *testDoer) error {
+			tx, err := txn_sql.Tx(ctx)
+			if err != nil {
+				return err
+			}
+			return tx.QueryRowContext(ctx, "SELECT count(*) FROM t").Scan(&n)
+		})
+	if err != nil || n != 1 {
+		t.Errorf("Expected 1 row in a consistent snapshot, got %d, %v", n, err)
+	}
+
+	var timeout int64
+	_, err = txn_sql.ExecuteRo[*nopStmt](ctx, mod, &testDoer{},
+		func(ctx context.Context, do *testDoer) error {
+			tx, err := txn_sql.Tx(ctx)
+			if err != nil {
+				return err
+			}
+			return tx.QueryRowContext(ctx, "SELECT @@session.innodb_lock_wait_timeout").Scan(&timeout)
+		}, txn.WithTimeout(3*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timeout != 3 {
+		t.Errorf("Expected innodb_lock_wait_timeout=3, got %d", timeout)
+	}
+}
+
+func TestApplySettings(t *testing.T) {
+	db := startServer(t)
+	db.SetMaxOpenConns(1)
+	mod := &testModule{}
+	mod.Init(db, func(context.Context, txn_sql.Beginner) (*nopStmt, error) { return &nopStmt{}, nil })
+	ctx := context.Background()
+	read := func(value *int64) doFunc {
+		return func(ctx context.Context, do *testDoer) error {
+			tx, err := txn_sql.Tx(ctx)
+			if err != nil {
+				return err
+			}
+			return tx.QueryRowContext(ctx, "SELECT @@session.net_read_timeout").Scan(value)
+		}
+	}
+
+	var timeout int64
+	_, err := txn_sql.ExecuteRw[*nopStmt](ctx, mod, &testDoer{}, read(&timeout),
+		txn.WithSettings(map[string]string{"NET_READ_TIMEOUT": "7"}))
+	if err != nil || timeout != 7 {
+		t.Fatalf("Expected net_read_timeout=7, got %d, %v", timeout, err)
+	}
+	// The database has a single connection, so the next transaction runs on the same one.
+	if _, err = txn_sql.ExecuteRw[*nopStmt](ctx, mod, &testDoer{}, read(&timeout)); err != nil || timeout != 30 {
+		t.Errorf("Expected net_read_timeout reset to its global value, got %d, %v", timeout, err)
+	}
+	other := startServer(t)
+	if _, ok := appliedOf(other).names["net_read_timeout"]; ok || len(appliedOf(db).names) != 2 {
+		t.Errorf("Expected the variables reset on the database they were set on only")
+	}
+	for value, expected := range map[string]any{"7": int64(7), "-1": int64(-1), "ON": "ON", "1.5": "1.5"} {
+		if v := settingValue(value); v != expected {
+			t.Errorf("Expected %q bound as %T, got %T", value, expected, v)
+		}
+	}
+
+	called := false
+	_, err = txn_sql.ExecuteRw[*nopStmt](ctx, mod, &testDoer{}, func(context.Context, *testDoer) error {
+		called = true
+		return nil
+	}, txn.WithSettings(map[string]string{"net_read_timeout = 1, GLOBAL max_connections": "1"}))
+	if !errors.Is(err, txn.ErrInvalidSetting) || called {
+		t.Errorf("Expected the setting name rejected before the DoFunc, got %v", err)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	db := startServer(t)
+	var holders []*nopStmt
+	mod := &testModule{}
+	mod.Init(db, func(context.Context, txn_sql.Beginner) (*nopStmt, error) {
+		holders = append(holders, &nopStmt{})
+		return holders[len(holders)-1], nil
+	})
+	observer := &retryObserver{}
+	attempts := 0
+	_, err := txn_sql.ExecuteRw[*nopStmt](context.Background(), mod, &testDoer{},
+		func(context.Context, *testDoer) error {
+			if attempts++; attempts == 1 {
+				return mysql.ErrInvalidConn
+			}
+			return nil
+		}, txn.WithObservers(observer))
+	if err != nil || attempts != 2 {
+		t.Fatalf("Expected the attempt retried once, got %d attempts, %v", attempts, err)
+	}
+	if observer.retries != 1 || observer.pings == 0 {
+		t.Errorf("Expected a retry after pinging, got %d retries and %d pings", observer.retries, observer.pings)
+	}
+	if len(holders) != 2 || holders[0].closed != 1 {
+		t.Errorf("Expected the holder closed on the invalid connection and rebuilt, got %d holders", len(holders))
+	}
+}
@@ -0,0 +1,174 @@
+package txn
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Event describes a transaction lifecycle event.
+type Event struct {
+	Title    string        // Title of the Doer.
+	Attempt  int           // Zero-based attempt index.
+	Pings    int           // Ping count, for OnPing, OnRetry and OnGiveUp.
+	Duration time.Duration // Elapsed time, or the ping interval for OnPing.
+	Err      error         // Error of the event, if any.
+}
+
+// Observer receives transaction lifecycle events.
+type Observer interface {
+	OnBegin(context.Context, Event)    // A transaction has begun, or failed to.
+	OnCommit(context.Context, Event)   // A transaction has committed.
+	OnRollback(context.Context, Event) // A transaction has rolled back.
+	OnRetry(context.Context, Event)    // A failed attempt is going to be retried.
+	OnPing(context.Context, Event)     // A ping was made before retrying.
+	OnPanic(context.Context, Event)    // A panic was recovered from the DoFunc.
+	OnGiveUp(context.Context, Event)   // No more attempts will be made.
+}
+
+// ObserverBase provides a no-op implementation for the Observer interface.
+type ObserverBase struct{}
+
+func (ObserverBase) OnBegin(context.Context, Event)    {}
+func (ObserverBase) OnCommit(context.Context, Event)   {}
+func (ObserverBase) OnRollback(context.Context, Event) {}
+func (ObserverBase) OnRetry(context.Context, Event)    {}
+func (ObserverBase) OnPing(context.Context, Event)     {}
+func (ObserverBase) OnPanic(context.Context, Event)    {}
+func (ObserverBase) OnGiveUp(context.Context, Event)   {}
+
+var globalObservers = struct {
+	sync.RWMutex
+	list []Observer
+}{list: []Observer{&SlogObserver{}}}
+
+// RegisterObserver adds an observer notified by every Doer.
+func RegisterObserver(o Observer) {
+	globalObservers.Lock()
+	defer globalObservers.Unlock()
+	globalObservers.list = append(globalObservers.list, o)
+}
+
+// SetObservers replaces the observers notified by every Doer.
+// By default, only a SlogObserver is registered.
+func SetObservers(list ...Observer) {
+	globalObservers.Lock()
+	defer globalObservers.Unlock()
+	globalObservers.list = append([]Observer(nil), list...)
+}
+
+// ObserverOf returns an observer notifying both the global observers and those of the Doer.
+func ObserverOf(doer interface{ Observers() []Observer }) Observer {
+	globalObservers.RLock()
+	list := append([]Observer(nil), globalObservers.list...)
+	globalObservers.RUnlock()
+	return multiObserver(append(list, doer.Observers()...))
+}
+
+type multiObserver []Observer
+
+func (m multiObserver) OnBegin(ctx context.Context, e Event) {
+	for _, o := range m {
+		o.OnBegin(ctx, e)
+	}
+}
+
+func (m multiObserver) OnCommit(ctx context.Context, e Event) {
+	for _, o := range m {
+		o.OnCommit(ctx, e)
+	}
+}
+
+func (m multiObserver) OnRollback(ctx context.Context, e Event) {
+	for _, o := range m {
+		o.OnRollback(ctx, e)
+	}
+}
+
+func (m multiObserver) OnRetry(ctx context.Context, e Event) {
+	for _, o := range m {
+		o.OnRetry(ctx, e)
+	}
+}
+
+func (m multiObserver) OnPing(ctx context.Context, e Event) {
+	for _, o := range m {
+		o.OnPing(ctx, e)
+	}
+}
+
+func (m multiObserver) OnPanic(ctx context.Context, e Event) {
+	for _, o := range m {
+		o.OnPanic(ctx, e)
+	}
+}
+
+func (m multiObserver) OnGiveUp(ctx context.Context, e Event) {
+	for _, o := range m {
+		o.OnGiveUp(ctx, e)
+	}
+}
+
+// SlogObserver logs events with slog.
+// The logger is taken from the "logger" context value, then the Logger field, then slog.Default.
+type SlogObserver struct {
+	Logger *slog.Logger
+}
+
+func (o *SlogObserver) log(ctx context.Context, e Event) *slog.Logger {
+	var logger *slog.Logger
+	if v, ok := ctx.Value("logger").(*slog.Logger); ok {
+		logger = v
+	} else if o.Logger != nil {
+		logger = o.Logger
+	} else {
+		logger = slog.Default()
+	}
+	return logger.With("T", e.Title)
+}
+
+func (o *SlogObserver) OnBegin(ctx context.Context, e Event) {
+	if e.Err == nil {
+		o.log(ctx, e).Info("+", "retries", e.Attempt)
+	}
+}
+
+func (o *SlogObserver) OnCommit(ctx context.Context, e Event) {
+	o.log(ctx, e).Info("+", "duration", e.Duration)
+}
+
+func (o *SlogObserver) OnRollback(ctx context.Context, e Event) {
+	o.log(ctx, e).Debug("-", "duration", e.Duration, "err", e.Err)
+}
+
+func (o *SlogObserver) OnRetry(ctx context.Context, e Event) {
+	o.log(ctx, e).Info("", "retries", e.Attempt, "pings", e.Pings, "err", e.Err)
+}
+
+func (o *SlogObserver) OnPing(ctx context.Context, e Event) {
+	o.log(ctx, e).Info("Ping", "retries", e.Attempt, "pings", e.Pings, "interval", e.Duration)
+}
+
+func (o *SlogObserver) OnPanic(ctx context.Context, e Event) {
+	o.log(ctx, e).Error("panic", "retries", e.Attempt, "err", e.Err)
+}
+
+func (o *SlogObserver) OnGiveUp(ctx context.Context, e Event) {
+	if e.Err != nil {
+		o.log(ctx, e).Error(e.Err.Error(), "retries", e.Attempt, "pings", e.Pings)
+	}
+}
+
+type attemptKey struct{}
+
+// WithAttempt returns a context carrying the attempt index.
+func WithAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt)
+}
+
+// AttemptFrom returns the attempt index carried by the context, 0 if none.
+func AttemptFrom(ctx context.Context) int {
+	attempt, _ := ctx.Value(attemptKey{}).(int)
+	return attempt
+}
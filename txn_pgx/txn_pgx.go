@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -34,16 +35,21 @@ type Doer[Stmt any] interface {
 // DoerBase provides a base implementation for the Doer interface.
 type DoerBase[Stmt any] struct {
 	txn.DoerBase[Options, Beginner]
-	stmt Stmt
+	stmtMu sync.RWMutex
+	stmt   Stmt
 }
 
 // Stmt returns the statement.
 func (do *DoerBase[S]) Stmt() S {
+	do.stmtMu.RLock()
+	defer do.stmtMu.RUnlock()
 	return do.stmt
 }
 
 // SetStmt sets the statement.
 func (do *DoerBase[S]) SetStmt(s S) {
+	do.stmtMu.Lock()
+	defer do.stmtMu.Unlock()
 	do.stmt = s
 }
 
@@ -106,16 +112,27 @@ func (w *rawTx) Rollback(ctx context.Context) error {
 	return w.raw.Rollback(ctx)
 }
 
+// Tx returns the pgx transaction of the DoFunc's context.
+func Tx(ctx context.Context) (RawTx, error) {
+	if t, ok := txn.TxnFrom(ctx); ok {
+		if raw, ok := t.(RawTxn); ok && raw.Raw() != nil {
+			return raw.Raw(), nil
+		}
+	}
+	return nil, errors.New("no pgx transaction on current context")
+}
+
 // ExecuteOnce executes a pgx transaction.
+// Lock-timeout and deadlock errors of the DoFunc are returned as a *LockError carrying lock-wait diagnostics.
 func ExecuteOnce[
 	D txn.Doer[Options, Beginner],
 ](ctx context.Context, beginner Beginner, do D, fn txn.DoFunc[Options, Beginner, D]) error {
-	if do.Timeout() > time.Millisecond {
+	if timeout := txn.FieldsOf(ctx, do).Timeout(); timeout > time.Millisecond {
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, do.Timeout())
+		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
-	return txn.Execute(ctx, beginner, do, fn)
+	return txn.Execute(ctx, beginner, do, diagnoseLocks(beginner, fn))
 }
 
 // Ping performs a ping operation.
@@ -125,17 +142,43 @@ func Ping(beginner Beginner, limit int, count txn.PingCount) (int, error) {
 	})
 }
 
-// BeginTxn begins a pgx transaction.
+// BeginTxn begins a pgx transaction, on the connection pinned by ExecuteOnConn if any,
+// then imports the snapshot exported by ExecuteSnapshot, issues the savepoint of txn.WithSavepoint if any,
+// and applies the session settings of the Doer.
+// The options of the per-call snapshot carried by ctx take precedence over opt.
 func BeginTxn(ctx context.Context, beginner Beginner, opt Options) (RawTxn, error) {
+	opt = txn.OptionsOf(ctx, opt)
 	var clone pgx.TxOptions
 	if opt != nil {
 		clone = *opt
 	} else {
 		clone = pgx.TxOptions{}
 	}
-	if tx, err := beginner.BeginTx(ctx, clone); err != nil {
-		return nil, err
+	var tx RawTx
+	var err error
+	if conn := connFrom(ctx); conn != nil {
+		tx, err = conn.BeginTx(ctx, clone)
 	} else {
-		return &rawTx{raw: tx}, nil
+		tx, err = beginner.BeginTx(ctx, clone)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = importSnapshot(ctx, tx); err == nil {
+		if name, ok := txn.SavepointFrom(ctx); ok {
+			if _, err = tx.Exec(ctx, "SAVEPOINT "+name); err != nil {
+				err = fmt.Errorf("%w [savepoint]", err)
+			}
+		}
+	}
+	if err == nil {
+		err = applySettings(ctx, tx)
+	}
+	if err != nil {
+		if x := tx.Rollback(ctx); x != nil {
+			return nil, fmt.Errorf("%w %w [rollback]", err, x)
+		}
+		return nil, err
 	}
+	return &rawTx{raw: tx}, nil
 }
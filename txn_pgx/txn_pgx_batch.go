@@ -0,0 +1,121 @@
+package txn_pgx
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Batch queues statements to be sent in one round trip on the current transaction.
+type Batch struct {
+	batch pgx.Batch
+	items []batchItem
+}
+
+type batchItem struct {
+	sql  string
+	read func(pgx.BatchResults) (pgconn.CommandTag, error)
+}
+
+// BatchResult holds the outcome of a queued statement.
+type BatchResult struct {
+	Index int
+	SQL   string
+	Tag   pgconn.CommandTag
+	Err   error
+}
+
+// BatchError attributes an error to the queued statement that caused it.
+type BatchError struct {
+	Index int
+	SQL   string
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%v [batch #%d] %s", e.Err, e.Index, e.SQL)
+}
+
+func (e *BatchError) Unwrap() error { return e.Err }
+
+// Len returns the number of queued statements.
+func (b *Batch) Len() int {
+	return len(b.items)
+}
+
+func (b *Batch) queue(sql string, args []any, read func(pgx.BatchResults) (pgconn.CommandTag, error)) int {
+	b.batch.Queue(sql, args...)
+	b.items = append(b.items, batchItem{sql: sql, read: read})
+	return len(b.items) - 1
+}
+
+// Exec queues a statement and returns its index.
+func (b *Batch) Exec(sql string, args ...any) int {
+	return b.queue(sql, args, func(br pgx.BatchResults) (pgconn.CommandTag, error) {
+		return br.Exec()
+	})
+}
+
+// Query queues a query whose rows are scanned into dst once the batch is sent, and returns its index.
+func Query[T any](b *Batch, dst *[]T, scan pgx.RowToFunc[T], sql string, args ...any) int {
+	return b.queue(sql, args, func(br pgx.BatchResults) (pgconn.CommandTag, error) {
+		rows, err := br.Query()
+		if err != nil {
+			return pgconn.CommandTag{}, err
+		}
+		values, err := pgx.CollectRows(rows, scan)
+		if err != nil {
+			return rows.CommandTag(), err
+		}
+		*dst = values
+		return rows.CommandTag(), nil
+	})
+}
+
+// QueryRow queues a query returning exactly one row scanned into dst once the batch is sent, and returns its index.
+func QueryRow[T any](b *Batch, dst *T, scan pgx.RowToFunc[T], sql string, args ...any) int {
+	return b.queue(sql, args, func(br pgx.BatchResults) (pgconn.CommandTag, error) {
+		rows, err := br.Query()
+		if err != nil {
+			return pgconn.CommandTag{}, err
+		}
+		value, err := pgx.CollectExactlyOneRow(rows, scan)
+		if err != nil {
+			return rows.CommandTag(), err
+		}
+		*dst = value
+		return rows.CommandTag(), nil
+	})
+}
+
+// SendBatch sends the queued statements in one round trip on the transaction of the DoFunc's context.
+// It returns a result per statement, and the first failure as a *BatchError.
+func SendBatch(ctx context.Context, b *Batch) ([]BatchResult, error) {
+	tx, err := Tx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]BatchResult, len(b.items))
+	if len(b.items) == 0 {
+		return results, nil
+	}
+	br := tx.SendBatch(ctx, &b.batch)
+	var first *BatchError
+	for i, item := range b.items {
+		results[i].Index = i
+		results[i].SQL = item.sql
+		results[i].Tag, results[i].Err = item.read(br)
+		if results[i].Err != nil && first == nil {
+			first = &BatchError{Index: i, SQL: item.sql, Err: results[i].Err}
+		}
+	}
+	if x := br.Close(); x != nil && first == nil {
+		return results, fmt.Errorf("%w [batch close]", x)
+	}
+	if first != nil {
+		return results, first
+	}
+	return results, nil
+}
@@ -0,0 +1,94 @@
+package txn_pgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/struqt/txn"
+)
+
+// Bulk describes a bulk load of rows into a target table.
+type Bulk struct {
+	Table    string             // Target table, optionally schema-qualified.
+	Columns  []string           // Columns of the copied rows.
+	Conflict []string           // Conflict target columns, none for a plain INSERT.
+	Update   []string           // Columns updated on conflict, none for DO NOTHING.
+	Rows     pgx.CopyFromSource // Rows to copy.
+}
+
+// BulkResult holds the row counts of a bulk load.
+type BulkResult struct {
+	Copied   int64
+	Inserted int64
+	Updated  int64
+}
+
+var stageSeq atomic.Int64
+
+// BulkUpsert copies rows into a temporary staging table created with ON COMMIT DROP,
+// then inserts them into the target table with ON CONFLICT handling.
+// It runs on the transaction of the DoFunc's context, so a rollback discards everything.
+// Rows must not repeat a conflict key, as a row cannot be updated twice by the same statement.
+func BulkUpsert(ctx context.Context, bulk Bulk) (BulkResult, error) {
+	var result BulkResult
+	if bulk.Table == "" || len(bulk.Columns) == 0 || bulk.Rows == nil {
+		return result, errors.Join(txn.ErrNilArgument, errors.New("[txn_pgx.BulkUpsert table, columns or rows]"))
+	}
+	if len(bulk.Update) > 0 && len(bulk.Conflict) == 0 {
+		return result, errors.Join(errors.New("bulk update requires conflict columns"), errors.New("[txn_pgx.BulkUpsert]"))
+	}
+	tx, err := Tx(ctx)
+	if err != nil {
+		return result, err
+	}
+	stage := fmt.Sprintf("txn_stage_%d", stageSeq.Add(1))
+	create, upsert := bulkStatements(bulk, stage)
+	if _, err = tx.Exec(ctx, create); err != nil {
+		return result, fmt.Errorf("%w [bulk stage]", err)
+	}
+	if result.Copied, err = tx.CopyFrom(ctx, pgx.Identifier{stage}, bulk.Columns, bulk.Rows); err != nil {
+		return result, fmt.Errorf("%w [bulk copy]", err)
+	}
+	if err = tx.QueryRow(ctx, upsert).Scan(&result.Inserted, &result.Updated); err != nil {
+		return result, fmt.Errorf("%w [bulk upsert]", err)
+	}
+	return result, nil
+}
+
+// bulkStatements returns the statement creating the staging table of the bulk load,
+// and the statement moving the staged rows into the target table and counting them.
+func bulkStatements(bulk Bulk, stage string) (create string, upsert string) {
+	target := pgx.Identifier(strings.Split(bulk.Table, ".")).Sanitize()
+	columns := identifiers(bulk.Columns)
+	create = fmt.Sprintf("CREATE TEMP TABLE %s ON COMMIT DROP AS SELECT %s FROM %s WITH NO DATA",
+		pgx.Identifier{stage}.Sanitize(), columns, target)
+	var conflict string
+	switch {
+	case len(bulk.Update) > 0:
+		sets := make([]string, len(bulk.Update))
+		for i, column := range bulk.Update {
+			id := pgx.Identifier{column}.Sanitize()
+			sets[i] = fmt.Sprintf("%s = EXCLUDED.%s", id, id)
+		}
+		conflict = fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", identifiers(bulk.Conflict), strings.Join(sets, ", "))
+	case len(bulk.Conflict) > 0:
+		conflict = fmt.Sprintf(" ON CONFLICT (%s) DO NOTHING", identifiers(bulk.Conflict))
+	}
+	upsert = fmt.Sprintf("WITH upserted AS ("+
+		"INSERT INTO %s (%s) SELECT %s FROM %s%s RETURNING (xmax = 0) AS inserted"+
+		") SELECT count(*) FILTER (WHERE inserted), count(*) FILTER (WHERE NOT inserted) FROM upserted",
+		target, columns, columns, pgx.Identifier{stage}.Sanitize(), conflict)
+	return create, upsert
+}
+
+func identifiers(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = pgx.Identifier{name}.Sanitize()
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,67 @@
+package txn_pgx
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type connKey struct{}
+
+func connFrom(ctx context.Context) *pgxpool.Conn {
+	conn, _ := ctx.Value(connKey{}).(*pgxpool.Conn)
+	return conn
+}
+
+// withoutConn unpins the connection of ctx, so that transactions begun with it take their own pool connection.
+func withoutConn(ctx context.Context) context.Context {
+	if connFrom(ctx) == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, connKey{}, (*pgxpool.Conn)(nil))
+}
+
+// ExecuteOnConn acquires a single pool connection and runs fn with a context pinning it,
+// so that the Doers executed with that context begin their transactions on the same session,
+// sharing temporary tables, session advisory locks, SET and cursors across transactions.
+// The session is reset before the connection is released, or else the connection is closed.
+func ExecuteOnConn[Stmt StmtHolder](
+	ctx context.Context, mod Module[Stmt], fn func(ctx context.Context, conn *pgxpool.Conn) error,
+) (err error) {
+	pool := mod.Beginner()
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("%w [conn acquire]", err)
+	}
+	defer func() {
+		c, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if x := resetSession(c, pool, conn); x != nil {
+			_ = conn.Hijack().Close(c)
+			if err == nil {
+				err = fmt.Errorf("%w [conn reset]", x)
+			}
+			return
+		}
+		conn.Release()
+	}()
+	return fn(context.WithValue(ctx, connKey{}, conn), conn)
+}
+
+// resetSession resets the session with DISCARD ALL, which also deallocates its prepared statements.
+// The statement caches of pgx are then cleared to match, and the pool's AfterConnect is run again
+// to prepare the statements registered with the module.
+func resetSession(ctx context.Context, pool Beginner, conn *pgxpool.Conn) error {
+	if _, err := conn.Exec(ctx, "DISCARD ALL"); err != nil {
+		return err
+	}
+	if err := conn.Conn().DeallocateAll(ctx); err != nil {
+		return err
+	}
+	if afterConnect := pool.Config().AfterConnect; afterConnect != nil {
+		return afterConnect(ctx, conn.Conn())
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package txn_pgx
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/struqt/txn"
+)
+
+var errorKinds = map[string]txn.ErrorKind{
+	"23505": txn.UniqueViolation,
+	"23503": txn.ForeignKeyViolation,
+	"23514": txn.CheckViolation,
+	"23502": txn.NotNullViolation,
+	"40001": txn.SerializationFailure,
+	"40P01": txn.Deadlock,
+	"55P03": txn.LockTimeout,
+	"57014": txn.StatementTimeout,
+}
+
+// MapError maps a *pgconn.PgError in the chain of err to a *txn.Error.
+func MapError(err error) *txn.Error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return nil
+	}
+	kind, ok := errorKinds[pgErr.Code]
+	if !ok {
+		return nil
+	}
+	return &txn.Error{
+		Kind:       kind,
+		Constraint: pgErr.ConstraintName,
+		Table:      pgErr.TableName,
+		Column:     pgErr.ColumnName,
+		Err:        err,
+	}
+}
+
+// Probe inspects pgx errors for lost connectivity.
+func Probe(err error) txn.ConnState {
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return txn.ConnRefused
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case strings.HasPrefix(pgErr.Code, "08"): // connection_exception
+			return txn.ConnReset
+		case pgErr.Code == "57P01", pgErr.Code == "57P02", pgErr.Code == "57P03": // admin_shutdown, crash_shutdown, cannot_connect_now
+			return txn.ConnReset
+		}
+		return txn.ConnOK
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return txn.ConnOK
+	}
+	if pgconn.Timeout(err) {
+		return txn.ConnTimeout
+	}
+	if pgconn.SafeToRetry(err) {
+		return txn.ConnReset
+	}
+	return txn.ConnOK
+}
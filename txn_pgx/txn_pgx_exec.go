@@ -2,11 +2,14 @@ package txn_pgx
 
 import (
 	"context"
-	"log/slog"
+	"errors"
+	"fmt"
 	"reflect"
+	"strings"
 	"sync"
-	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/struqt/txn"
 )
 
@@ -14,11 +17,16 @@ type StmtHolder = any
 
 type Module[Stmt StmtHolder] interface {
 	Beginner() Beginner
+	Prepare(ctx context.Context, do Doer[Stmt]) error
+	Invalidate(err error) error
 }
 
 type ModuleBase[Stmt StmtHolder] struct {
-	mutex    sync.Mutex
-	beginner Beginner
+	mutex      sync.Mutex
+	beginner   Beginner
+	holder     Stmt
+	statements map[string]string
+	restart    bool
 }
 
 func (b *ModuleBase[_]) Beginner() Beginner {
@@ -31,6 +39,66 @@ func (b *ModuleBase[_]) Init(beginner Beginner) {
 	b.beginner = beginner
 }
 
+// Register sets the statement holder handed to Doers and the named statements prepared on every connection.
+// The pool config must have AfterConnect set to the module's AfterConnect.
+// Connections already in the pool are reset so that they are prepared on reconnect.
+func (b *ModuleBase[Stmt]) Register(holder Stmt, statements map[string]string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.holder = holder
+	b.statements = make(map[string]string, len(statements))
+	for name, sql := range statements {
+		b.statements[name] = sql
+	}
+	if b.beginner != nil {
+		b.beginner.Reset()
+	}
+}
+
+// AfterConnect prepares the registered statements on a new pool connection.
+func (b *ModuleBase[_]) AfterConnect(ctx context.Context, conn *pgx.Conn) error {
+	b.mutex.Lock()
+	statements := b.statements
+	b.mutex.Unlock()
+	for name, sql := range statements {
+		if _, err := conn.Prepare(ctx, name, sql); err != nil {
+			return fmt.Errorf("%w [prepare %s]", err, name)
+		}
+	}
+	return nil
+}
+
+// Prepare hands the statement holder to the Doer.
+func (b *ModuleBase[Stmt]) Prepare(_ context.Context, do Doer[Stmt]) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	do.SetStmt(b.holder)
+	return nil
+}
+
+// Invalidate resets the pool when the error of a failed attempt reports a stale prepared statement,
+// i.e. "cached plan must not change result type", so that every connection re-prepares on reconnect.
+// It returns err, marked Retryable when the pool was reset.
+func (b *ModuleBase[_]) Invalidate(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || !stalePlan(pgErr) {
+		return err
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if len(b.statements) == 0 || b.beginner == nil {
+		return err
+	}
+	b.beginner.Reset()
+	return txn.Retryable(err, 0)
+}
+
+// stalePlan reports whether the server error is "cached plan must not change result type",
+// raised with feature_not_supported (0A000) when a prepared statement outlives a change of the table it reads.
+func stalePlan(pgErr *pgconn.PgError) bool {
+	return pgErr.Code == "0A000" && strings.Contains(pgErr.Message, "cached plan must not change result type")
+}
+
 func title[Stmt StmtHolder, D Doer[Stmt]](do D) string {
 	if do.Title() != "" {
 		return ""
@@ -62,39 +130,39 @@ func Execute[Stmt StmtHolder, D Doer[Stmt]](
 	ctx context.Context, mod Module[Stmt], doer D,
 	fn txn.DoFunc[Options, Beginner, D], setters ...txn.DoerFieldSetter,
 ) (D, error) {
-	doer.Mutate(setters...)
-	var logger *slog.Logger
-	if v, ok := ctx.Value("logger").(*slog.Logger); ok {
-		logger = v
-	} else {
-		logger = slog.Default()
-	}
-	log := logger.With("T", doer.Title())
-	log.Info("+")
-	var x, err error
-	var pings int
-	var retries = -1
-	t1 := time.Now()
-retry:
-	retries++
-	if retries > doer.MaxRetry() && doer.MaxRetry() > 0 {
-		if err != nil {
-			log.Error(err.Error(), "retries", retries, "pings", pings)
-		}
-		return doer, err
-	}
-	if err = ExecuteOnce(ctx, mod.Beginner(), doer, fn); err == nil {
-		log.Info("+", "duration", time.Now().Sub(t1))
-		return doer, nil
+	fields := doer.Snapshot(setters...)
+	opt, _ := fields.Options().(Options)
+	if m, ok := mod.(interface{ CockroachRestart() bool }); ok && m.CockroachRestart() {
+		ctx = txn.WithSavepoint(ctx, "cockroach_restart")
+		fn = cockroachRestart(fn)
 	}
-	pings, x = Ping(mod.Beginner(), doer.MaxPing(), func(cnt int, i time.Duration) {
-		log.Info("Ping", "retries", retries, "pings", cnt, "interval", i)
+	err := txn.Retry(ctx, fields, doer, txn.Steps{
+		Prepare: func(ctx context.Context) error {
+			return mod.Prepare(ctx, doer)
+		},
+		Execute: func(ctx context.Context) error {
+			return ExecuteOnce(ctx, mod.Beginner(), doer, fn)
+		},
+		Recover: func(_ context.Context, err error) error {
+			err = txn.MapError(err, MapError)
+			if txn.IsPermanent(err) || Failover(err, opt) {
+				return err
+			}
+			return mod.Invalidate(err)
+		},
+		Probes: []txn.Probe{func(err error) txn.ConnState {
+			if Failover(err, opt) {
+				return txn.ConnReset
+			}
+			return Probe(err)
+		}},
+		Ping: func(err error, limit int, count txn.PingCount) (int, error) {
+			if Failover(err, opt) {
+				mod.Beginner().Reset()
+				return PingPrimary(mod.Beginner(), limit, count)
+			}
+			return Ping(mod.Beginner(), limit, count)
+		},
 	})
-	connected := x == nil && pings <= 1
-	if connected && retries > 0 {
-		log.Error(err.Error(), "retries", retries, "pings", pings)
-		return doer, err
-	}
-	log.Info("", "retries", retries, "pings", pings, "err", err)
-	goto retry
+	return doer, err
 }
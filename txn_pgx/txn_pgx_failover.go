@@ -0,0 +1,39 @@
+package txn_pgx
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/struqt/txn"
+)
+
+var ErrStandby = errors.New("connected to a standby")
+
+// Failover reports whether err shows that a read-write transaction ran on a standby,
+// i.e. read_only_sql_transaction (25006) raised outside of a read-only transaction,
+// as happens on connections to a former primary after a failover.
+func Failover(err error, opt Options) bool {
+	if opt != nil && opt.AccessMode == pgx.ReadOnly {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "25006"
+}
+
+// PingPrimary performs a ping operation which only succeeds once the pool reaches a primary.
+// Multi-host DSNs should set target_session_attrs=read-write, so that new connections resolve to the new primary.
+func PingPrimary(beginner Beginner, limit int, count txn.PingCount) (int, error) {
+	return txn.Ping(limit, count, func(ctx context.Context) error {
+		var recovery bool
+		if err := beginner.QueryRow(ctx, "SELECT pg_is_in_recovery()").Scan(&recovery); err != nil {
+			return err
+		}
+		if recovery {
+			beginner.Reset()
+			return ErrStandby
+		}
+		return nil
+	})
+}
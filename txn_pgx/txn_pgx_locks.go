@@ -0,0 +1,122 @@
+package txn_pgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/struqt/txn"
+)
+
+// LockHolder describes another backend involved in a lock wait.
+type LockHolder struct {
+	PID             int32
+	Query           string
+	ApplicationName string
+	State           string
+	XactAge         time.Duration // Age of its transaction, an upper bound of how long it has held the lock.
+	BlockedBy       []int32
+	// Candidate is set for a backend merely holding a lock on a relation locked by the failed transaction,
+	// rather than named by the server in the deadlock report. Such a lock may or may not conflict with the one waited for,
+	// e.g. ACCESS SHARE locks of plain readers only block ACCESS EXCLUSIVE ones.
+	Candidate bool
+}
+
+// LockError attaches lock-wait diagnostics, gathered on a side connection,
+// to a lock_timeout (55P03) or deadlock (40P01) error.
+type LockError struct {
+	PID     int32 // Backend PID of the failed transaction.
+	Holders []LockHolder
+	Err     error
+}
+
+func (e *LockError) Error() string {
+	var holders, candidates []int32
+	for _, h := range e.Holders {
+		if h.Candidate {
+			candidates = append(candidates, h.PID)
+		} else {
+			holders = append(holders, h.PID)
+		}
+	}
+	msg := fmt.Sprintf("%v [lock holders %v]", e.Err, holders)
+	if len(candidates) > 0 {
+		msg += fmt.Sprintf(" [lock candidates %v]", candidates)
+	}
+	return msg
+}
+
+func (e *LockError) Unwrap() error { return e.Err }
+
+var deadlockPID = regexp.MustCompile(`Process (\d+)`)
+
+const lockHoldersQuery = `SELECT a.pid, coalesce(a.query, ''), coalesce(a.application_name, ''), coalesce(a.state, ''),
+	coalesce(extract(epoch FROM now() - a.xact_start), 0)::float8, pg_blocking_pids(a.pid), a.pid <> ALL($2::int4[])
+FROM pg_stat_activity a
+WHERE a.pid <> $1 AND (a.pid = ANY($2::int4[]) OR ($3 AND a.pid IN (
+	SELECT other.pid FROM pg_locks mine JOIN pg_locks other
+	ON other.locktype = mine.locktype
+	AND other.database IS NOT DISTINCT FROM mine.database
+	AND other.relation IS NOT DISTINCT FROM mine.relation
+	WHERE mine.pid = $1 AND mine.relation IS NOT NULL AND other.pid <> $1 AND other.granted)))
+ORDER BY a.xact_start`
+
+// diagnoseLocks wraps the DoFunc, so that lock errors carry diagnostics gathered before the rollback
+// releases the locks of the failed transaction.
+func diagnoseLocks[D txn.Doer[Options, Beginner]](
+	beginner Beginner, fn txn.DoFunc[Options, Beginner, D]) txn.DoFunc[Options, Beginner, D] {
+	return func(ctx context.Context, do D) error {
+		err := fn(ctx, do)
+		var pgErr *pgconn.PgError
+		if err == nil || !errors.As(err, &pgErr) || (pgErr.Code != "55P03" && pgErr.Code != "40P01") {
+			return err
+		}
+		tx, x := Tx(ctx)
+		if x != nil {
+			return err
+		}
+		pid := int32(tx.Conn().PgConn().PID())
+		pids := []int32{}
+		for _, m := range deadlockPID.FindAllStringSubmatch(pgErr.Detail, -1) {
+			if v, x := strconv.ParseInt(m[1], 10, 32); x == nil {
+				pids = append(pids, int32(v))
+			}
+		}
+		holders, x := lockHolders(ctx, beginner, pid, pids, pgErr.Code == "55P03")
+		if x != nil {
+			return fmt.Errorf("%w %v [lock diagnostics]", err, x)
+		}
+		return &LockError{PID: pid, Holders: holders, Err: err}
+	}
+}
+
+// lockDiagnosticsTimeout bounds the wait for a side connection and the query, e.g. on an exhausted pool.
+const lockDiagnosticsTimeout = 500 * time.Millisecond
+
+// lockHolders queries the backends named by pids, and when related is set, those holding locks
+// on the relations locked by the failed transaction, as candidates.
+// It runs within the DoFunc's ctx, bounded by lockDiagnosticsTimeout.
+func lockHolders(ctx context.Context, beginner Beginner, pid int32, pids []int32, related bool) ([]LockHolder, error) {
+	ctx, cancel := context.WithTimeout(ctx, lockDiagnosticsTimeout)
+	defer cancel()
+	rows, err := beginner.Query(ctx, lockHoldersQuery, pid, pids, related)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var holders []LockHolder
+	for rows.Next() {
+		var h LockHolder
+		var age float64
+		if err = rows.Scan(&h.PID, &h.Query, &h.ApplicationName, &h.State, &age, &h.BlockedBy, &h.Candidate); err != nil {
+			return nil, err
+		}
+		h.XactAge = time.Duration(age * float64(time.Second))
+		holders = append(holders, h)
+	}
+	return holders, rows.Err()
+}
@@ -0,0 +1,144 @@
+package txn_pgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/struqt/txn"
+)
+
+// Notify queues a notification on the transaction of the DoFunc's context.
+// Postgres delivers it to listeners only when the transaction commits.
+func Notify(ctx context.Context, channel string, payload string) error {
+	tx, err := Tx(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, "SELECT pg_notify($1, $2)", channel, payload); err != nil {
+		return fmt.Errorf("%w [notify %s]", err, channel)
+	}
+	return nil
+}
+
+// NotificationHandler handles a notification received by a Listener.
+type NotificationHandler func(ctx context.Context, n *pgconn.Notification)
+
+// Listener receives notifications on a dedicated connection taken from the pool,
+// and dispatches them to the handlers of their channel.
+type Listener struct {
+	mutex    sync.Mutex
+	beginner Beginner
+	maxPing  int
+	count    txn.PingCount
+	handlers map[string][]NotificationHandler
+}
+
+// NewListener creates a Listener on the pool.
+// After losing its connection, it pings the pool up to maxPing times before reconnecting and re-listening.
+func NewListener(beginner Beginner, maxPing int, count txn.PingCount) *Listener {
+	return &Listener{
+		beginner: beginner,
+		maxPing:  maxPing,
+		count:    count,
+		handlers: make(map[string][]NotificationHandler),
+	}
+}
+
+// Handle registers a handler for the channel. Handlers must be registered before Run.
+func (l *Listener) Handle(channel string, handler NotificationHandler) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.handlers[channel] = append(l.handlers[channel], handler)
+}
+
+const (
+	listenBackoffMin = 100 * time.Millisecond
+	listenBackoffMax = 30 * time.Second
+)
+
+// Run listens and dispatches notifications until ctx is done.
+// Reconnects are spaced by a backoff doubling up to 30s, which restarts once listening succeeds.
+// After a lost connection, the pool is pinged before reconnecting.
+func (l *Listener) Run(ctx context.Context) error {
+	backoff := listenBackoffMin
+	for {
+		listening, err := l.listen(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, txn.ErrNilArgument) {
+			return err
+		}
+		if listening {
+			backoff = listenBackoffMin
+		}
+		if txn.ProbeConn(err, Probe) != txn.ConnOK {
+			_, _ = Ping(l.beginner, l.maxPing, l.count)
+		}
+		jitter := time.Duration(float64(backoff) * (rand.Float64()*0.1 + 0.95))
+		if txn.Sleep(ctx, jitter) != nil {
+			return nil
+		}
+		if backoff *= 2; backoff > listenBackoffMax {
+			backoff = listenBackoffMax
+		}
+	}
+}
+
+// listen reports whether it got as far as listening on every channel, along with the error that ended it.
+func (l *Listener) listen(ctx context.Context) (bool, error) {
+	l.mutex.Lock()
+	channels := make([]string, 0, len(l.handlers))
+	for channel := range l.handlers {
+		channels = append(channels, channel)
+	}
+	l.mutex.Unlock()
+	if len(channels) == 0 {
+		return false, errors.Join(txn.ErrNilArgument, errors.New("[txn_pgx.Listener handlers]"))
+	}
+	pooled, err := l.beginner.Acquire(ctx)
+	if err != nil {
+		return false, fmt.Errorf("%w [listener acquire]", err)
+	}
+	conn := pooled.Hijack()
+	defer func() {
+		c, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = conn.Close(c)
+	}()
+	for _, channel := range channels {
+		if _, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			return false, fmt.Errorf("%w [listen %s]", err, channel)
+		}
+	}
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return true, fmt.Errorf("%w [listener wait]", err)
+		}
+		l.mutex.Lock()
+		handlers := l.handlers[n.Channel]
+		l.mutex.Unlock()
+		for _, handler := range handlers {
+			l.dispatch(ctx, handler, n)
+		}
+	}
+}
+
+// dispatch runs a handler, recovering its panic so that it reaches the observers instead of ending Run.
+func (l *Listener) dispatch(ctx context.Context, handler NotificationHandler, n *pgconn.Notification) {
+	defer func() {
+		if p := recover(); p != nil {
+			err := fmt.Errorf("%v --- debug.Stack --- %s", p, debug.Stack())
+			txn.ObserverOf(txn.DoerFields{}).OnPanic(ctx, txn.Event{Title: "Listen`" + n.Channel, Err: err})
+		}
+	}()
+	handler(ctx, n)
+}
@@ -0,0 +1,49 @@
+package txn_pgx
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/struqt/txn"
+)
+
+// SetCockroachRestart sets whether DoFuncs run within the CockroachDB client-side retry protocol.
+// The DoFunc then runs after SAVEPOINT cockroach_restart, and on a serialization failure (40001)
+// it is re-run after ROLLBACK TO SAVEPOINT cockroach_restart, within the same transaction,
+// until RELEASE SAVEPOINT cockroach_restart succeeds or MaxRetry restarts are exhausted.
+func (b *ModuleBase[_]) SetCockroachRestart(on bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.restart = on
+}
+
+// CockroachRestart reports whether DoFuncs run within the CockroachDB client-side retry protocol.
+func (b *ModuleBase[_]) CockroachRestart() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.restart
+}
+
+// serializationFailure reports whether err is a serialization failure (40001).
+func serializationFailure(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "40001"
+}
+
+// cockroachRestart wraps the DoFunc in the CockroachDB client-side retry protocol, run by txn.Restart.
+func cockroachRestart[D txn.Doer[Options, Beginner]](
+	fn txn.DoFunc[Options, Beginner, D]) txn.DoFunc[Options, Beginner, D] {
+	return func(ctx context.Context, do D) error {
+		tx, err := Tx(ctx)
+		if err != nil {
+			return err
+		}
+		exec := func(ctx context.Context, statement string) error {
+			_, err := tx.Exec(ctx, statement)
+			return err
+		}
+		return txn.Restart(ctx, txn.FieldsOf(ctx, do), do, exec, "cockroach_restart", serializationFailure,
+			func(ctx context.Context) error { return fn(ctx, do) })
+	}
+}
@@ -0,0 +1,27 @@
+package txn_pgx
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/struqt/txn"
+)
+
+// applySettings applies the session settings of the per-call snapshot carried by ctx in a single statement,
+// with set_config(..., true) so that they only last until the end of the transaction.
+// As built by txn.PostgresSettings, application_name and statement_timeout are derived by default
+// from the title and the ctx deadline.
+func applySettings(ctx context.Context, tx RawTx) error {
+	fields, ok := txn.FieldsFrom(ctx)
+	if !ok {
+		return nil
+	}
+	query, args := txn.PostgresSettings(ctx, fields)
+	if query == "" {
+		return nil
+	}
+	if _, err := tx.Exec(ctx, query, args...); err != nil {
+		return fmt.Errorf("%w [settings]", err)
+	}
+	return nil
+}
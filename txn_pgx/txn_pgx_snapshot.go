@@ -0,0 +1,82 @@
+package txn_pgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/struqt/txn"
+)
+
+type snapshotKey struct{}
+
+func withSnapshot(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, snapshotKey{}, id)
+}
+
+// importSnapshot makes the transaction read the snapshot exported to ctx, if any.
+// It must run before any other statement of the transaction.
+func importSnapshot(ctx context.Context, tx RawTx) error {
+	id, ok := ctx.Value(snapshotKey{}).(string)
+	if !ok {
+		return nil
+	}
+	if _, err := tx.Exec(ctx, "SET TRANSACTION SNAPSHOT '"+strings.ReplaceAll(id, "'", "''")+"'"); err != nil {
+		return fmt.Errorf("%w [snapshot %s]", err, id)
+	}
+	return nil
+}
+
+// ExecuteSnapshot opens a REPEATABLE READ coordinator transaction, exports its snapshot,
+// then runs each Doer in parallel in a read-only transaction importing that snapshot,
+// so that all of them read the same data. Each Doer typically carries the key range it reads.
+// At most workers Doers run at once, each holding its own pool connection while the coordinator holds one more,
+// even under a ctx pinning a connection with ExecuteOnConn, which is then held as well.
+// Workers are bounded by the connections of the pool left to them, which workers <= 0 takes all of.
+func ExecuteSnapshot[Stmt StmtHolder, D Doer[Stmt]](
+	ctx context.Context, mod Module[Stmt], doers []D, workers int,
+	fn txn.DoFunc[Options, Beginner, D], setters ...txn.DoerFieldSetter,
+) ([]D, error) {
+	left := int(mod.Beginner().Config().MaxConns) - 1
+	if connFrom(ctx) != nil {
+		left--
+	}
+	if left < 1 {
+		return doers, errors.New("no pool connection left for the workers beside the coordinator [snapshot]")
+	}
+	if workers <= 0 || workers > left {
+		workers = left
+	}
+	ctx = withoutConn(ctx)
+	options := pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly}
+	coordinator, err := mod.Beginner().BeginTx(ctx, options)
+	if err != nil {
+		return doers, fmt.Errorf("%w [snapshot begin]", err)
+	}
+	defer func() { _ = coordinator.Rollback(context.Background()) }()
+	var id string
+	if err = coordinator.QueryRow(ctx, "SELECT pg_export_snapshot()").Scan(&id); err != nil {
+		return doers, fmt.Errorf("%w [snapshot export]", err)
+	}
+	ctx = withSnapshot(ctx, id)
+	errs := make([]error, len(doers))
+	slots := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	wg.Add(len(doers))
+	for i, do := range doers {
+		slots <- struct{}{}
+		go func(i int, do D) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			s := append(do.ReadOnlySetters(title[Stmt](do)), txn.WithOptions(&options))
+			if _, err := Execute(ctx, mod, do, fn, append(s, setters...)...); err != nil {
+				errs[i] = fmt.Errorf("%w [snapshot worker #%d]", err, i)
+			}
+		}(i, do)
+	}
+	wg.Wait()
+	return doers, errors.Join(errs...)
+}
@@ -0,0 +1,649 @@
+package txn_pgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/struqt/txn"
+)
+
+// reply is the answer of the fake server to a simple query.
+type reply struct {
+	tag    string
+	fields []pgproto3.FieldDescription
+	rows   [][]string
+	err    *pgproto3.ErrorResponse
+	notify []*pgproto3.NotificationResponse
+}
+
+func columns(oid uint32, names ...string) []pgproto3.FieldDescription {
+	fields := make([]pgproto3.FieldDescription, len(names))
+	for i, name := range names {
+		fields[i] = pgproto3.FieldDescription{Name: []byte(name), DataTypeOID: oid, DataTypeSize: -1, TypeModifier: -1}
+	}
+	return fields
+}
+
+func failure(code, message string) reply {
+	return reply{err: &pgproto3.ErrorResponse{Severity: "ERROR", Code: code, Message: message}}
+}
+
+// fakeServer speaks enough of the PostgreSQL protocol for a pool in simple protocol mode:
+// the startup, simple queries answered by handle, and the Parse/Describe/Sync round trip of conn.Prepare.
+type fakeServer struct {
+	listener net.Listener
+	handle   func(query string) reply
+	mu       sync.Mutex
+	conns    int
+	queries  []string
+	prepared []string
+}
+
+func newFakeServer(t *testing.T, handle func(query string) reply) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{listener: listener, handle: handle}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) pool(t *testing.T, configure func(*pgxpool.Config)) *pgxpool.Pool {
+	config, err := pgxpool.ParseConfig(fmt.Sprintf("postgres://test@%s/test?sslmode=disable", s.listener.Addr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+	if configure != nil {
+		configure(config)
+	}
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func (s *fakeServer) stats() (conns int, queries []string, prepared []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns, append([]string(nil), s.queries...), append([]string(nil), s.prepared...)
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	backend := pgproto3.NewBackend(conn, conn)
+	if _, err := backend.ReceiveStartupMessage(); err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.conns++
+	pid := uint32(s.conns)
+	s.mu.Unlock()
+	backend.Send(&pgproto3.AuthenticationOk{})
+	backend.Send(&pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"})
+	backend.Send(&pgproto3.ParameterStatus{Name: "standard_conforming_strings", Value: "on"})
+	backend.Send(&pgproto3.BackendKeyData{ProcessID: pid, SecretKey: 1})
+	backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+	status := byte('I')
+	for {
+		if err := backend.Flush(); err != nil {
+			return
+		}
+		msg, err := backend.Receive()
+		if err != nil {
+			return
+		}
+		switch msg := msg.(type) {
+		case *pgproto3.Query:
+			// A batch in simple protocol mode arrives as statements joined by semicolons,
+			// which run until the first error.
+			for _, query := range strings.Split(strings.TrimSuffix(msg.String, ";"), ";") {
+				var ok bool
+				if status, ok = s.reply(backend, query, status); !ok {
+					break
+				}
+			}
+			backend.Send(&pgproto3.ReadyForQuery{TxStatus: status})
+		case *pgproto3.Parse:
+			s.mu.Lock()
+			s.prepared = append(s.prepared, msg.Name)
+			s.mu.Unlock()
+			backend.Send(&pgproto3.ParseComplete{})
+		case *pgproto3.Describe:
+			backend.Send(&pgproto3.ParameterDescription{})
+			backend.Send(&pgproto3.NoData{})
+		case *pgproto3.Sync:
+			backend.Send(&pgproto3.ReadyForQuery{TxStatus: status})
+		case *pgproto3.Terminate:
+			return
+		}
+	}
+}
+
+func (s *fakeServer) reply(backend *pgproto3.Backend, query string, status byte) (byte, bool) {
+	s.mu.Lock()
+	s.queries = append(s.queries, query)
+	s.mu.Unlock()
+	var r reply
+	if s.handle != nil {
+		r = s.handle(query)
+	}
+	verb := strings.ToUpper(strings.Fields(query + " -")[0])
+	switch {
+	case r.err != nil:
+		backend.Send(r.err)
+		if status == 'T' {
+			return 'E', false
+		}
+		return status, false
+	case r.fields != nil:
+		backend.Send(&pgproto3.RowDescription{Fields: r.fields})
+		for _, row := range r.rows {
+			values := make([][]byte, len(row))
+			for i, v := range row {
+				values[i] = []byte(v)
+			}
+			backend.Send(&pgproto3.DataRow{Values: values})
+		}
+		backend.Send(&pgproto3.CommandComplete{CommandTag: []byte(fmt.Sprintf("SELECT %d", len(r.rows)))})
+	default:
+		if r.tag == "" {
+			r.tag = verb
+		}
+		switch verb {
+		case "BEGIN":
+			status = 'T'
+		case "COMMIT", "ROLLBACK":
+			if verb == "COMMIT" && status == 'E' {
+				r.tag = "ROLLBACK"
+			}
+			status = 'I'
+		}
+		backend.Send(&pgproto3.CommandComplete{CommandTag: []byte(r.tag)})
+	}
+	for _, n := range r.notify {
+		backend.Send(n)
+	}
+	return status, true
+}
+
+type testDoer struct {
+	DoerBase[string]
+}
+
+func (do *testDoer) BeginTxn(ctx context.Context, beginner Beginner) (txn.Txn, error) {
+	return BeginTxn(ctx, beginner, nil)
+}
+
+func TestInvalidate(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeServer(t, nil)
+	mod := &ModuleBase[string]{}
+	pool := server.pool(t, func(config *pgxpool.Config) { config.AfterConnect = mod.AfterConnect })
+	mod.Init(pool)
+	mod.Register("holder", map[string]string{"user_by_id": "SELECT name FROM users WHERE id = $1"})
+	if err := pool.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, prepared := server.stats(); fmt.Sprint(prepared) != "[user_by_id]" {
+		t.Fatalf("Expected the statement prepared on connect, got %v", prepared)
+	}
+	doer := &testDoer{}
+	if err := mod.Prepare(ctx, doer); err != nil || doer.Stmt() != "holder" {
+		t.Fatalf("Expected the holder handed to the Doer, got %q and %v", doer.Stmt(), err)
+	}
+
+	unsupported := &pgconn.PgError{Code: "0A000", Message: "LOCK TABLE is not supported"}
+	if err := mod.Invalidate(unsupported); err != unsupported {
+		t.Errorf("Expected other feature_not_supported errors to be returned as is, got %v", err)
+	}
+	stale := &pgconn.PgError{Code: "0A000", Message: "cached plan must not change result type"}
+	err := mod.Invalidate(fmt.Errorf("%w [txn do]", stale))
+	if _, ok := txn.RetryAfter(err); !ok || !errors.Is(err, stale) {
+		t.Fatalf("Expected a stale plan to be retryable, got %v", err)
+	}
+	if err = pool.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if conns, _, prepared := server.stats(); conns != 2 || fmt.Sprint(prepared) != "[user_by_id user_by_id]" {
+		t.Errorf("Expected the statement prepared again on a new connection, got %d and %v", conns, prepared)
+	}
+}
+
+func TestSendBatch(t *testing.T) {
+	server := newFakeServer(t, func(query string) reply {
+		switch query {
+		case "SELECT id FROM items":
+			return reply{fields: columns(pgtype.Int4OID, "id"), rows: [][]string{{"1"}, {"2"}}}
+		case "SELECT name FROM users WHERE id = 1":
+			return reply{fields: columns(pgtype.TextOID, "name"), rows: [][]string{{"ann"}}}
+		case "SELECT name FROM users WHERE id = 2":
+			return reply{fields: columns(pgtype.TextOID, "name")}
+		case "INSERT INTO items VALUES (1)":
+			return failure("23505", "duplicate key value violates unique constraint")
+		}
+		return reply{}
+	})
+	pool := server.pool(t, nil)
+	var ids []int32
+	var name string
+	var results []BatchResult
+	var err error
+	send := func(b *Batch) {
+		results, err = nil, nil
+		_ = ExecuteOnce(context.Background(), pool, &testDoer{}, func(ctx context.Context, do *testDoer) error {
+			results, err = SendBatch(ctx, b)
+			return nil
+		})
+	}
+
+	b := &Batch{}
+	b.Exec("UPDATE items SET seen = true")
+	Query(b, &ids, pgx.RowTo[int32], "SELECT id FROM items")
+	QueryRow(b, &name, pgx.RowTo[string], "SELECT name FROM users WHERE id = 1")
+	send(b)
+	if err != nil || len(results) != 3 || results[0].Tag.String() != "UPDATE" {
+		t.Fatalf("Expected 3 results, got %v and %v", results, err)
+	}
+	if fmt.Sprint(ids) != "[1 2]" || name != "ann" || results[1].Tag.RowsAffected() != 2 {
+		t.Errorf("Expected the rows scanned into their destinations, got %v and %q", ids, name)
+	}
+
+	b = &Batch{}
+	b.Exec("UPDATE items SET seen = true")
+	QueryRow(b, &name, pgx.RowTo[string], "SELECT name FROM users WHERE id = 2")
+	send(b)
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || batchErr.Index != 1 || !errors.Is(err, pgx.ErrNoRows) || results[0].Err != nil {
+		t.Errorf("Expected no rows attributed to #1, got %v", err)
+	}
+
+	b = &Batch{}
+	b.Exec("UPDATE items SET seen = true")
+	b.Exec("INSERT INTO items VALUES (1)")
+	b.Exec("DELETE FROM items")
+	send(b)
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &batchErr) || batchErr.Index != 1 || batchErr.SQL != "INSERT INTO items VALUES (1)" ||
+		!errors.As(err, &pgErr) || pgErr.Code != "23505" {
+		t.Fatalf("Expected the unique violation attributed to #1, got %v", err)
+	}
+	if results[0].Err != nil || results[1].Err == nil || results[2].Err == nil {
+		t.Errorf("Expected every statement from #1 on to fail, got %+v", results)
+	}
+}
+
+func TestBulkStatements(t *testing.T) {
+	bulk := Bulk{
+		Table:    "app.order items",
+		Columns:  []string{"id", `na"me`},
+		Conflict: []string{"id"},
+		Update:   []string{`na"me`},
+	}
+	create, upsert := bulkStatements(bulk, "txn_stage_1")
+	if want := `CREATE TEMP TABLE "txn_stage_1" ON COMMIT DROP AS SELECT "id", "na""me" ` +
+		`FROM "app"."order items" WITH NO DATA`; create != want {
+		t.Errorf("Expected %s, got %s", want, create)
+	}
+	if want := `WITH upserted AS (INSERT INTO "app"."order items" ("id", "na""me") ` +
+		`SELECT "id", "na""me" FROM "txn_stage_1" ` +
+		`ON CONFLICT ("id") DO UPDATE SET "na""me" = EXCLUDED."na""me" RETURNING (xmax = 0) AS inserted) ` +
+		`SELECT count(*) FILTER (WHERE inserted), count(*) FILTER (WHERE NOT inserted) FROM upserted`; upsert != want {
+		t.Errorf("Expected %s, got %s", want, upsert)
+	}
+	bulk.Update = nil
+	if _, upsert = bulkStatements(bulk, "s"); !strings.Contains(upsert, `FROM "s" ON CONFLICT ("id") DO NOTHING RETURNING`) {
+		t.Errorf("Expected DO NOTHING on conflict, got %s", upsert)
+	}
+	bulk.Conflict = nil
+	if _, upsert = bulkStatements(bulk, "s"); strings.Contains(upsert, "ON CONFLICT") {
+		t.Errorf("Expected a plain insert, got %s", upsert)
+	}
+}
+
+type panicObserver struct {
+	txn.ObserverBase
+	panics chan txn.Event
+}
+
+func (o *panicObserver) OnPanic(_ context.Context, e txn.Event) {
+	o.panics <- e
+}
+
+func TestListener(t *testing.T) {
+	t.Run("backoff on failed listen", func(t *testing.T) {
+		server := newFakeServer(t, func(query string) reply {
+			return failure("42501", "permission denied for channel")
+		})
+		l := NewListener(server.pool(t, nil), 1, nil)
+		l.Handle("jobs", func(context.Context, *pgconn.Notification) {})
+		ctx, cancel := context.WithTimeout(context.Background(), 450*time.Millisecond)
+		defer cancel()
+		if err := l.Run(ctx); err != nil {
+			t.Fatal(err)
+		}
+		// Attempts at about 0, 100ms and 300ms, the next one being due at 700ms.
+		if _, queries, _ := server.stats(); len(queries) < 2 || len(queries) > 4 {
+			t.Errorf("Expected reconnects spaced by a backoff, got %d attempts", len(queries))
+		}
+	})
+
+	t.Run("handler panic", func(t *testing.T) {
+		observer := &panicObserver{panics: make(chan txn.Event, 1)}
+		txn.SetObservers(observer)
+		t.Cleanup(func() { txn.SetObservers(&txn.SlogObserver{}) })
+		server := newFakeServer(t, func(query string) reply {
+			return reply{notify: []*pgproto3.NotificationResponse{
+				{Channel: "jobs", Payload: "boom"},
+				{Channel: "jobs", Payload: "42"},
+			}}
+		})
+		l := NewListener(server.pool(t, nil), 1, nil)
+		received := make(chan string, 1)
+		l.Handle("jobs", func(_ context.Context, n *pgconn.Notification) {
+			if n.Payload == "boom" {
+				panic(n.Payload)
+			}
+			received <- n.Payload
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- l.Run(ctx) }()
+		if payload := <-received; payload != "42" {
+			t.Errorf("Expected the notification after the panic delivered, got %q", payload)
+		}
+		if e := <-observer.panics; e.Title != "Listen`jobs" || !strings.Contains(e.Err.Error(), "boom") {
+			t.Errorf("Expected the panic reported to the observers, got %+v", e)
+		}
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestSettings(t *testing.T) {
+	server := newFakeServer(t, nil)
+	pool := server.pool(t, nil)
+	doer := &testDoer{}
+	doer.Mutate(txn.WithTitle("TxnRw`test"), txn.WithTimeout(2*time.Second))
+	ctx := txn.WithFields(context.Background(), doer.Snapshot())
+	err := ExecuteOnce(ctx, pool, doer, func(context.Context, *testDoer) error { return nil })
+	_, queries, _ := server.stats()
+	if err != nil || len(queries) != 3 || !strings.HasPrefix(strings.ReplaceAll(queries[1], " ", ""),
+		"SELECTset_config('application_name','TxnRw`test',true),set_config('statement_timeout','1") {
+		t.Errorf("Expected application_name and statement_timeout set by default, got %v and %v", queries, err)
+	}
+}
+
+func TestCockroachRestart(t *testing.T) {
+	server := newFakeServer(t, nil)
+	mod := &ModuleBase[string]{}
+	mod.Init(server.pool(t, nil))
+	mod.SetCockroachRestart(true)
+	_, err := ExecuteRw[string](context.Background(), mod, &testDoer{},
+		func(context.Context, *testDoer) error { return nil }, txn.WithTitle("restart"))
+	_, queries, _ := server.stats()
+	// CockroachDB requires SAVEPOINT cockroach_restart to be the first statement of the transaction.
+	if err != nil || len(queries) != 5 || queries[1] != "SAVEPOINT cockroach_restart" ||
+		!strings.HasPrefix(queries[2], "SELECT set_config(") || queries[3] != "RELEASE SAVEPOINT cockroach_restart" {
+		t.Errorf("Expected the savepoint issued before the settings, got %v and %v", queries, err)
+	}
+}
+
+func TestExecuteSnapshot(t *testing.T) {
+	server := newFakeServer(t, func(query string) reply {
+		if query == "SELECT pg_export_snapshot()" {
+			return reply{fields: columns(pgtype.TextOID, "pg_export_snapshot"), rows: [][]string{{"00000003-1B"}}}
+		}
+		return reply{}
+	})
+	mod := &ModuleBase[string]{}
+	mod.Init(server.pool(t, func(config *pgxpool.Config) { config.MaxConns = 8 }))
+	doers := make([]*testDoer, 6)
+	for i := range doers {
+		doers[i] = &testDoer{}
+	}
+	var mu sync.Mutex
+	var running, peak, done int
+	_, err := ExecuteSnapshot(context.Background(), mod, doers, 2, func(context.Context, *testDoer) error {
+		mu.Lock()
+		if running++; running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		running--
+		done++
+		mu.Unlock()
+		return nil
+	})
+	if err != nil || done != len(doers) || peak != 2 {
+		t.Fatalf("Expected 6 Doers run 2 at a time, got %d, %d and %v", done, peak, err)
+	}
+	imports := 0
+	_, queries, _ := server.stats()
+	for _, query := range queries {
+		if query == "SET TRANSACTION SNAPSHOT '00000003-1B'" {
+			imports++
+		}
+	}
+	if queries[0] != "begin isolation level repeatable read read only" || imports != len(doers) {
+		t.Errorf("Expected every worker to import the exported snapshot, got %d in %v", imports, queries)
+	}
+
+	// Under a pinned connection of a pool of 3, the coordinator and a single worker take the others.
+	small := &ModuleBase[string]{}
+	small.Init(server.pool(t, func(config *pgxpool.Config) { config.MaxConns = 3 }))
+	peak = 0
+	err = ExecuteOnConn(context.Background(), small, func(ctx context.Context, _ *pgxpool.Conn) error {
+		_, err := ExecuteSnapshot(ctx, small, doers, 0, func(ctx context.Context, do *testDoer) error {
+			if connFrom(ctx) != nil {
+				return errors.New("worker on the pinned connection")
+			}
+			mu.Lock()
+			if running++; running > peak {
+				peak = running
+			}
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return nil
+		})
+		return err
+	})
+	if err != nil || peak != 1 {
+		t.Errorf("Expected the Doers run one at a time off the pinned connection, got %d and %v", peak, err)
+	}
+
+	single := &ModuleBase[string]{}
+	single.Init(server.pool(t, func(config *pgxpool.Config) { config.MaxConns = 1 }))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err = ExecuteSnapshot(ctx, single, doers, 0, func(context.Context, *testDoer) error { return nil }); err == nil ||
+		errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a pool of a single connection rejected, got %v", err)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	standby := &pgconn.PgError{Code: "25006", Message: "cannot execute UPDATE in a read-only transaction"}
+	if !Failover(fmt.Errorf("%w [txn do]", standby), nil) ||
+		Failover(standby, &pgx.TxOptions{AccessMode: pgx.ReadOnly}) || Failover(errors.New("other"), nil) {
+		t.Errorf("Expected only read-write transactions failing with 25006 to fail over")
+	}
+
+	run := func(t *testing.T, promoted bool, writes int) (int, int, error) {
+		var mu sync.Mutex
+		attempts := 0
+		server := newFakeServer(t, func(query string) reply {
+			mu.Lock()
+			defer mu.Unlock()
+			switch query {
+			case "UPDATE accounts SET balance = 0":
+				if attempts++; attempts <= writes {
+					return failure("25006", standby.Message)
+				}
+			case "SELECT pg_is_in_recovery()":
+				value := "t"
+				if promoted {
+					value = "f"
+				}
+				return reply{fields: columns(pgtype.BoolOID, "pg_is_in_recovery"), rows: [][]string{{value}}}
+			}
+			return reply{}
+		})
+		mod := &ModuleBase[string]{}
+		mod.Init(server.pool(t, nil))
+		_, err := ExecuteRw(context.Background(), mod, &testDoer{}, func(ctx context.Context, do *testDoer) error {
+			tx, err := Tx(ctx)
+			if err == nil {
+				_, err = tx.Exec(ctx, "UPDATE accounts SET balance = 0")
+			}
+			return err
+		}, txn.WithMaxPing(1), txn.WithMaxRetry(1))
+		conns, _, _ := server.stats()
+		return attempts, conns, err
+	}
+
+	t.Run("primary reached", func(t *testing.T) {
+		if attempts, conns, err := run(t, true, 1); err != nil || attempts != 2 || conns < 2 {
+			t.Errorf("Expected a retry on a reset pool, got %d attempts on %d connections and %v", attempts, conns, err)
+		}
+	})
+
+	t.Run("standby only", func(t *testing.T) {
+		_, _, err := run(t, false, 2)
+		if !errors.Is(err, ErrStandby) || !errors.As(err, new(*pgconn.PgError)) {
+			t.Errorf("Expected the standby error joined with the ping failure, got %v", err)
+		}
+	})
+}
+
+func TestDiagnoseLocks(t *testing.T) {
+	holders := append(append(append(columns(pgtype.Int4OID, "pid"),
+		columns(pgtype.TextOID, "query", "application_name", "state")...),
+		columns(pgtype.Float8OID, "age")...),
+		append(columns(pgtype.Int4ArrayOID, "blocked_by"), columns(pgtype.BoolOID, "candidate")...)...)
+	var stall time.Duration
+	run := func(t *testing.T, failed *pgproto3.ErrorResponse) error {
+		server := newFakeServer(t, func(query string) reply {
+			switch {
+			case query == "UPDATE accounts SET balance = 0":
+				return reply{err: failed}
+			case strings.HasPrefix(query, "SELECT a.pid"):
+				time.Sleep(stall)
+				return reply{fields: holders, rows: [][]string{
+					{"13", "UPDATE accounts SET balance = 1", "TxnRw`Transfer", "active", "1.5", "{12}", "f"},
+					{"14", "SELECT * FROM accounts", "TxnRo`Report", "idle in transaction", "0.5", "{}", "t"},
+				}}
+			}
+			return reply{}
+		})
+		return ExecuteOnce(context.Background(), server.pool(t, nil), &testDoer{},
+			func(ctx context.Context, do *testDoer) error {
+				tx, err := Tx(ctx)
+				if err == nil {
+					_, err = tx.Exec(ctx, "UPDATE accounts SET balance = 0")
+				}
+				return err
+			})
+	}
+	err := run(t, &pgproto3.ErrorResponse{Severity: "ERROR", Code: "40P01", Message: "deadlock detected",
+		Detail: "Process 1 waits for ShareLock on transaction 7; blocked by process 13.\n" +
+			"Process 13 waits for ShareLock on transaction 6; blocked by process 1."})
+	var lockErr *LockError
+	if !errors.As(err, &lockErr) || !errors.As(err, new(*pgconn.PgError)) {
+		t.Fatalf("Expected a *LockError, got %v", err)
+	}
+	if h := lockErr.Holders; len(h) != 2 || h[0].PID != 13 || h[0].Candidate ||
+		h[0].XactAge != 1500*time.Millisecond || fmt.Sprint(h[0].BlockedBy) != "[12]" ||
+		h[0].ApplicationName != "TxnRw`Transfer" || !h[1].Candidate {
+		t.Errorf("Unexpected lock holders %+v", h)
+	}
+	if !strings.Contains(err.Error(), "[lock holders [13]] [lock candidates [14]]") {
+		t.Errorf("Expected holders and candidates told apart, got %v", err)
+	}
+
+	stall = lockDiagnosticsTimeout + 100*time.Millisecond
+	err = run(t, &pgproto3.ErrorResponse{Severity: "ERROR", Code: "55P03", Message: "lock not available"})
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "55P03" || !strings.Contains(err.Error(), "[lock diagnostics]") {
+		t.Fatalf("Expected the lock error with the failure of its diagnostics, got %v", err)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the timed out diagnostics not to make the lock error a timeout, got %v", err)
+	}
+}
+
+func TestExecuteOnConn(t *testing.T) {
+	var mu sync.Mutex
+	inTxn := false
+	server := newFakeServer(t, func(query string) reply {
+		mu.Lock()
+		defer mu.Unlock()
+		if query == "DISCARD ALL" && inTxn {
+			return failure("25001", "DISCARD ALL cannot run inside a transaction block")
+		}
+		return reply{}
+	})
+	mod := &ModuleBase[string]{}
+	pool := server.pool(t, func(config *pgxpool.Config) { config.AfterConnect = mod.AfterConnect })
+	mod.Init(pool)
+	mod.Register("holder", map[string]string{"user_by_id": "SELECT name FROM users WHERE id = $1"})
+	ctx := context.Background()
+	err := ExecuteOnConn(ctx, mod, func(ctx context.Context, conn *pgxpool.Conn) error {
+		_, err := conn.Exec(ctx, "CREATE TEMP TABLE scratch (id int)")
+		return err
+	})
+	conns, queries, prepared := server.stats()
+	if err != nil || conns != 1 || fmt.Sprint(prepared) != "[user_by_id user_by_id]" ||
+		fmt.Sprint(queries[1:]) != "[DISCARD ALL deallocate all]" {
+		t.Fatalf("Expected the session reset and prepared again, got %d, %v, %v and %v", conns, queries, prepared, err)
+	}
+
+	err = ExecuteOnConn(ctx, mod, func(ctx context.Context, conn *pgxpool.Conn) error {
+		mu.Lock()
+		defer mu.Unlock()
+		inTxn = true
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "[conn reset]") {
+		t.Errorf("Expected the failed reset reported, got %v", err)
+	}
+	mu.Lock()
+	inTxn = false
+	mu.Unlock()
+	if err = pool.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if conns, _, _ = server.stats(); conns != 2 {
+		t.Errorf("Expected the connection failing its reset to be closed, got %d connections", conns)
+	}
+}
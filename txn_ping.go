@@ -54,7 +54,7 @@ func Ping(limit int, count PingCount, ping func(context.Context) error) (cnt int
 		cnt++
 		if cnt > limit {
 			if err != nil {
-				err = fmt.Errorf("reached retry limit (%d), last error: %v", limit, err)
+				err = fmt.Errorf("reached retry limit (%d), last error: %w", limit, err)
 			}
 			break
 		}
@@ -0,0 +1,180 @@
+package txn_redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/struqt/txn"
+)
+
+// TxOptions holds the keys WATCHed as the transaction begins, before the DoFunc runs.
+type TxOptions struct {
+	Watch []string
+}
+
+type (
+	RawTx    = *Multi
+	Beginner = *redis.Client
+	Options  = *TxOptions
+)
+
+type RawTxn interface {
+	txn.Txn
+	Raw() RawTx
+}
+
+// Doer defines the interface for Redis transaction operations.
+type Doer interface {
+	txn.Doer[Options, Beginner]
+	DefaultSetters(title string) []txn.DoerFieldSetter
+}
+
+// DoerBase provides a base implementation for the Doer interface.
+type DoerBase struct {
+	txn.DoerBase[Options, Beginner]
+}
+
+func (do *DoerBase) DefaultSetters(title string) []txn.DoerFieldSetter {
+	return []txn.DoerFieldSetter{
+		txn.WithTitle(fmt.Sprintf("Txn`%s", title)),
+		txn.WithRethrow(false),
+		txn.WithTimeout(2 * time.Second),
+		txn.WithMaxPing(4),
+		txn.WithMaxRetry(8),
+		txn.WithOptions(&TxOptions{}),
+	}
+}
+
+// Multi is an optimistic Redis transaction on a dedicated connection.
+// Reads run at once on the connection, after WATCHing the keys they depend on,
+// and writes are queued on the pipeline, to be sent in MULTI/EXEC at commit.
+// EXEC fails with redis.TxFailedErr if a watched key changed meanwhile.
+type Multi struct {
+	conn *redis.Conn
+	pipe redis.Pipeliner
+}
+
+// Watch WATCHes the keys, so that the transaction fails to commit if any of them changes before EXEC.
+func (t *Multi) Watch(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	args := make([]any, 0, len(keys)+1)
+	args = append(args, "watch")
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	cmd := redis.NewStatusCmd(ctx, args...)
+	_ = t.conn.Process(ctx, cmd)
+	if err := cmd.Err(); err != nil {
+		return fmt.Errorf("%w [watch]", err)
+	}
+	return nil
+}
+
+// Conn returns the connection, whose commands run at once, e.g. to read watched keys.
+func (t *Multi) Conn() *redis.Conn {
+	return t.conn
+}
+
+// Pipe returns the pipeline of the commands queued for MULTI/EXEC.
+func (t *Multi) Pipe() redis.Pipeliner {
+	return t.pipe
+}
+
+func (t *Multi) unwatch(ctx context.Context) error {
+	cmd := redis.NewStatusCmd(ctx, "unwatch")
+	_ = t.conn.Process(ctx, cmd)
+	return cmd.Err()
+}
+
+type rawTx struct {
+	raw  *Multi
+	done bool
+}
+
+func (w *rawTx) Raw() RawTx {
+	return w.raw
+}
+
+// Commit sends the queued commands in MULTI/EXEC, then releases the connection.
+func (w *rawTx) Commit(ctx context.Context) error {
+	if w.raw == nil {
+		return errors.New("cancelling Commit, Raw is nil")
+	}
+	if w.done {
+		return nil
+	}
+	defer w.release()
+	if w.raw.pipe.Len() == 0 {
+		return w.raw.unwatch(ctx)
+	}
+	_, err := w.raw.pipe.Exec(ctx)
+	return err
+}
+
+// Rollback discards the queued commands and the watches, then releases the connection.
+// It does nothing after Commit, which ends the transaction even when EXEC fails.
+func (w *rawTx) Rollback(ctx context.Context) error {
+	if w.raw == nil {
+		return errors.New("cancelling Rollback, Raw is nil")
+	}
+	if w.done {
+		return nil
+	}
+	defer w.release()
+	w.raw.pipe.Discard()
+	return w.raw.unwatch(ctx)
+}
+
+// release closes the connection, only once.
+func (w *rawTx) release() {
+	w.done = true
+	_ = w.raw.conn.Close()
+}
+
+// Tx returns the Redis transaction of the DoFunc's context.
+func Tx(ctx context.Context) (RawTx, error) {
+	if t, ok := txn.TxnFrom(ctx); ok {
+		if raw, ok := t.(RawTxn); ok && raw.Raw() != nil {
+			return raw.Raw(), nil
+		}
+	}
+	return nil, errors.New("no Redis transaction on current context")
+}
+
+// ExecuteOnce executes a Redis transaction.
+func ExecuteOnce[D txn.Doer[Options, Beginner]](
+	ctx context.Context, beginner Beginner, do D, fn txn.DoFunc[Options, Beginner, D]) error {
+	if timeout := txn.FieldsOf(ctx, do).Timeout(); timeout > time.Millisecond {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return txn.Execute(ctx, beginner, do, fn)
+}
+
+// Ping performs a ping operation with the PING command.
+func Ping(beginner Beginner, limit int, count txn.PingCount) (int, error) {
+	return txn.Ping(limit, count, func(ctx context.Context) error {
+		return beginner.Ping(ctx).Err()
+	})
+}
+
+// BeginTxn takes a dedicated connection from the pool, and WATCHes the keys of the options.
+// The options of the per-call snapshot carried by ctx take precedence over opt.
+func BeginTxn(ctx context.Context, beginner Beginner, opt Options) (RawTxn, error) {
+	opt = txn.OptionsOf(ctx, opt)
+	conn := beginner.Conn()
+	tx := &Multi{conn: conn, pipe: conn.TxPipeline()}
+	if opt != nil {
+		if err := tx.Watch(ctx, opt.Watch...); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return &rawTx{raw: tx}, nil
+}
@@ -0,0 +1,25 @@
+package txn_redis
+
+import (
+	"errors"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/struqt/txn"
+)
+
+// MapError maps redis.TxFailedErr, returned by EXEC after a watched key changed,
+// to a txn.SerializationFailure, which is retried.
+func MapError(err error) *txn.Error {
+	if errors.Is(err, redis.TxFailedErr) {
+		return &txn.Error{Kind: txn.SerializationFailure, Err: err}
+	}
+	return nil
+}
+
+// Probe inspects go-redis errors for lost connectivity.
+func Probe(err error) txn.ConnState {
+	if errors.Is(err, redis.ErrClosed) {
+		return txn.ConnReset
+	}
+	return txn.ConnOK
+}
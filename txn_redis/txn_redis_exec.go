@@ -0,0 +1,76 @@
+package txn_redis
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/struqt/txn"
+)
+
+type ModuleSetter func(*ModuleBase)
+
+type Module interface {
+	Beginner() Beginner
+}
+
+type ModuleBase struct {
+	mutex    sync.Mutex
+	beginner Beginner
+}
+
+func (b *ModuleBase) Beginner() Beginner {
+	return b.beginner
+}
+
+func (b *ModuleBase) Mutate(setters ...ModuleSetter) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, setter := range setters {
+		setter(b)
+	}
+}
+
+func WithBeginner(value Beginner) ModuleSetter {
+	return func(do *ModuleBase) {
+		do.beginner = value
+	}
+}
+
+func Execute[D Doer](
+	ctx context.Context, mod Module, do D,
+	fn txn.DoFunc[Options, Beginner, D], setters ...txn.DoerFieldSetter,
+) (D, error) {
+	s := append(do.DefaultSetters(title(do)), setters...)
+	return execute(ctx, mod, do, fn, s...)
+}
+
+func title[D Doer](do D) string {
+	if do.Title() != "" {
+		return ""
+	}
+	t := reflect.TypeOf(do)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+func execute[D Doer](
+	ctx context.Context, mod Module, doer D,
+	fn txn.DoFunc[Options, Beginner, D], setters ...txn.DoerFieldSetter,
+) (D, error) {
+	err := txn.Retry(ctx, doer.Snapshot(setters...), doer, txn.Steps{
+		Execute: func(ctx context.Context) error {
+			return ExecuteOnce(ctx, mod.Beginner(), doer, fn)
+		},
+		Recover: func(_ context.Context, err error) error {
+			return txn.MapError(err, MapError)
+		},
+		Probes: []txn.Probe{Probe},
+		Ping: func(_ error, limit int, count txn.PingCount) (int, error) {
+			return Ping(mod.Beginner(), limit, count)
+		},
+	})
+	return doer, err
+}
@@ -0,0 +1,97 @@
+package txn_redis
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/struqt/txn"
+)
+
+type testDoer struct {
+	DoerBase
+}
+
+func (do *testDoer) BeginTxn(ctx context.Context, beginner Beginner) (txn.Txn, error) {
+	return BeginTxn(ctx, beginner, nil)
+}
+
+type doFunc = txn.DoFunc[Options, Beginner, *testDoer]
+
+func TestExecute(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer func() { _ = client.Close() }()
+	mod := &ModuleBase{}
+	mod.Mutate(WithBeginner(client))
+	ctx := context.Background()
+
+	// The first attempt sees the counter changed behind its back, and is re-run.
+	attempts := 0
+	incr := doFunc(func(ctx context.Context, do *testDoer) error {
+		tx, err := Tx(ctx)
+		if err != nil {
+			return err
+		}
+		if err = tx.Watch(ctx, "counter"); err != nil {
+			return err
+		}
+		n, err := tx.Conn().Get(ctx, "counter").Int()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		attempts++
+		if attempts == 1 {
+			server.Set("counter", "10")
+		}
+		tx.Pipe().Set(ctx, "counter", n+1, 0)
+		return nil
+	})
+	if _, err := Execute[*testDoer](ctx, mod, &testDoer{}, incr); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := server.Get("counter"); attempts != 2 || got != "11" {
+		t.Errorf("Expected 2 attempts and counter=11, got %d and %s", attempts, got)
+	}
+
+	// A conflict on every attempt gives up after MaxRetry.
+	attempts = 0
+	conflict := doFunc(func(ctx context.Context, do *testDoer) error {
+		tx, err := Tx(ctx)
+		if err != nil {
+			return err
+		}
+		attempts++
+		server.Set("counter", "0")
+		tx.Pipe().Incr(ctx, "counter")
+		return nil
+	})
+	_, err := Execute[*testDoer](ctx, mod, &testDoer{}, conflict,
+		txn.WithOptions(&TxOptions{Watch: []string{"counter"}}), txn.WithMaxRetry(2))
+	if !errors.Is(err, txn.SerializationFailure) || !errors.Is(err, redis.TxFailedErr) || attempts != 3 {
+		t.Errorf("Expected a serialization failure after 3 attempts, got %d and %v", attempts, err)
+	}
+
+	// An error of a queued command is returned by EXEC, and given up on at once.
+	attempts = 0
+	wrongType := doFunc(func(ctx context.Context, do *testDoer) error {
+		tx, err := Tx(ctx)
+		if err != nil {
+			return err
+		}
+		attempts++
+		tx.Pipe().LPush(ctx, "counter", "x")
+		return nil
+	})
+	_, err = Execute[*testDoer](ctx, mod, &testDoer{}, wrongType)
+	if err == nil || !strings.Contains(err.Error(), "WRONGTYPE") || errors.Is(err, redis.ErrClosed) || attempts != 1 {
+		t.Errorf("Expected a WRONGTYPE error given up on after 1 attempt, got %d and %v", attempts, err)
+	}
+
+	if cnt, err := Ping(client, 1, nil); cnt != 1 || err != nil {
+		t.Errorf("Expected cnt=1 and err=nil, got cnt=%d and err=%v", cnt, err)
+	}
+}
@@ -0,0 +1,167 @@
+package txn
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Phase identifies a timed step of a transaction attempt.
+type Phase int
+
+const (
+	PhasePrepare  Phase = iota // Preparing module resources, e.g. statements.
+	PhaseBegin                 // Beginning the transaction.
+	PhaseDo                    // Running the DoFunc.
+	PhaseCommit                // Committing the transaction.
+	PhaseRollback              // Rolling back the transaction.
+)
+
+// Attempt holds the statistics of a single transaction attempt.
+type Attempt struct {
+	Index    int
+	Start    time.Time
+	Pings    int
+	Prepare  time.Duration
+	Begin    time.Duration
+	Do       time.Duration
+	Commit   time.Duration
+	Rollback time.Duration
+	Err      error
+}
+
+// Report collects per-attempt and per-phase statistics of an execution.
+// All methods are safe to call on a nil *Report, so callers may fill it in unconditionally.
+// A Report must not be shared by concurrent executions.
+type Report struct {
+	Title    string
+	Start    time.Time
+	Duration time.Duration
+	Attempts []*Attempt
+	Errors   []error
+	Err      error
+}
+
+// Reset clears the report for a new execution.
+func (r *Report) Reset(title string) {
+	if r == nil {
+		return
+	}
+	*r = Report{Title: title, Start: time.Now()}
+}
+
+// NextAttempt records the beginning of a new attempt.
+func (r *Report) NextAttempt() {
+	if r == nil {
+		return
+	}
+	r.Attempts = append(r.Attempts, &Attempt{Index: len(r.Attempts), Start: time.Now()})
+}
+
+// Track adds the time elapsed since start to the given phase of the current attempt.
+func (r *Report) Track(phase Phase, start time.Time) {
+	a := r.current()
+	if a == nil {
+		return
+	}
+	d := time.Since(start)
+	switch phase {
+	case PhasePrepare:
+		a.Prepare += d
+	case PhaseBegin:
+		a.Begin += d
+	case PhaseDo:
+		a.Do += d
+	case PhaseCommit:
+		a.Commit += d
+	case PhaseRollback:
+		a.Rollback += d
+	}
+}
+
+// AddPings adds the ping count to the current attempt.
+func (r *Report) AddPings(count int) {
+	if a := r.current(); a != nil {
+		a.Pings += count
+	}
+}
+
+// Fail records an error of the current attempt.
+func (r *Report) Fail(err error) {
+	if r == nil || err == nil {
+		return
+	}
+	if a := r.current(); a != nil {
+		a.Err = err
+	}
+	r.Errors = append(r.Errors, err)
+}
+
+// Finish records the final outcome of the execution.
+func (r *Report) Finish(err error) {
+	if r == nil {
+		return
+	}
+	r.Err = err
+	r.Duration = time.Since(r.Start)
+}
+
+// Succeeded reports whether the execution has committed.
+func (r *Report) Succeeded() bool {
+	return r != nil && len(r.Attempts) > 0 && r.Err == nil
+}
+
+func (r *Report) current() *Attempt {
+	if r == nil || len(r.Attempts) == 0 {
+		return nil
+	}
+	return r.Attempts[len(r.Attempts)-1]
+}
+
+// MarshalJSON encodes the report with errors rendered as strings.
+func (r *Report) MarshalJSON() ([]byte, error) {
+	type attempt struct {
+		Index    int           `json:"index"`
+		Start    time.Time     `json:"start"`
+		Pings    int           `json:"pings"`
+		Prepare  time.Duration `json:"prepare"`
+		Begin    time.Duration `json:"begin"`
+		Do       time.Duration `json:"do"`
+		Commit   time.Duration `json:"commit"`
+		Rollback time.Duration `json:"rollback"`
+		Err      string        `json:"err,omitempty"`
+	}
+	v := struct {
+		Title     string        `json:"title"`
+		Start     time.Time     `json:"start"`
+		Duration  time.Duration `json:"duration"`
+		Succeeded bool          `json:"succeeded"`
+		Attempts  []attempt     `json:"attempts"`
+		Errors    []string      `json:"errors,omitempty"`
+		Err       string        `json:"err,omitempty"`
+	}{
+		Title:     r.Title,
+		Start:     r.Start,
+		Duration:  r.Duration,
+		Succeeded: r.Succeeded(),
+		Attempts:  make([]attempt, 0, len(r.Attempts)),
+		Err:       errString(r.Err),
+	}
+	for _, a := range r.Attempts {
+		v.Attempts = append(v.Attempts, attempt{
+			Index: a.Index, Start: a.Start, Pings: a.Pings,
+			Prepare: a.Prepare, Begin: a.Begin, Do: a.Do, Commit: a.Commit, Rollback: a.Rollback,
+			Err: errString(a.Err),
+		})
+	}
+	for _, err := range r.Errors {
+		v.Errors = append(v.Errors, errString(err))
+	}
+	return json.Marshal(v)
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
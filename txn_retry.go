@@ -0,0 +1,165 @@
+package txn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+const (
+	transientBackoffMin = 5 * time.Millisecond
+	transientBackoffMax = 500 * time.Millisecond
+)
+
+// Steps are the backend specific steps of the retry loop driven by Retry.
+type Steps struct {
+	// Prepare, if set, runs before each attempt. Its failure is handled as the failure of the attempt.
+	Prepare func(ctx context.Context) error
+	// Execute runs one attempt, typically a transaction run by the backend's ExecuteOnce.
+	Execute func(ctx context.Context) error
+	// Recover, if set, maps the error of a failed attempt, and discards what the error shows to be unusable.
+	Recover func(ctx context.Context, err error) error
+	// Probes tell lost connectivity on top of the generic checks of ProbeConn.
+	Probes []Probe
+	// Ping waits for the connectivity lost by a failed attempt to come back, within limit pings.
+	Ping func(err error, limit int, count PingCount) (int, error)
+}
+
+// Retry runs the attempts of a Doer with the per-call snapshot fields, until one succeeds.
+// A failed attempt is retried after the delay of a Retryable error, after a backoff when its error is Transient,
+// or once Ping returns when its error shows lost connectivity; any other error is returned.
+// The backoff doubles from 5ms up to 500ms over the Transient failures of the call, plus up to as much jitter.
+// An attempt that ran out of its own timeout while ctx is still live is deemed stalled on its connection.
+// The Doer is reset before each attempt, and the report and observers of the fields follow the attempts.
+func Retry(ctx context.Context, fields DoerFields, doer any, steps Steps) error {
+	ctx = WithFields(ctx, fields)
+	report := fields.Report()
+	report.Reset(fields.Title())
+	observer := ObserverOf(fields)
+	var x, err error
+	var pings int
+	var retries = -1
+	var backoff = transientBackoffMin
+	t0 := time.Now()
+	event := func(err error) Event {
+		return Event{Title: fields.Title(), Attempt: retries, Pings: pings, Duration: time.Since(t0), Err: err}
+	}
+retry:
+	retries++
+	if retries > fields.MaxRetry() && fields.MaxRetry() > 0 {
+		observer.OnGiveUp(ctx, event(err))
+		report.Finish(err)
+		return err
+	}
+	report.NextAttempt()
+	attempt := WithAttempt(ctx, retries)
+	if err = nil; steps.Prepare != nil {
+		tp := time.Now()
+		err = steps.Prepare(attempt)
+		report.Track(PhasePrepare, tp)
+	}
+	if err == nil {
+		ResetAttempt(doer)
+		if err = steps.Execute(attempt); err == nil {
+			report.Finish(nil)
+			return nil
+		}
+		if steps.Recover != nil {
+			err = steps.Recover(attempt, err)
+		}
+	}
+	report.Fail(err)
+	if IsPermanent(err) {
+		observer.OnGiveUp(ctx, event(err))
+		report.Finish(err)
+		return err
+	}
+	if after, ok := RetryAfter(err); ok || Transient(err) {
+		if !ok {
+			after = backoff + time.Duration(rand.Int63n(int64(backoff)))
+			if backoff *= 2; backoff > transientBackoffMax {
+				backoff = transientBackoffMax
+			}
+		}
+		observer.OnRetry(ctx, event(err))
+		if x = Sleep(ctx, after); x != nil {
+			err = fmt.Errorf("%w [retry] %w", err, x)
+			observer.OnGiveUp(ctx, event(err))
+			report.Finish(err)
+			return err
+		}
+		goto retry
+	}
+	state := ProbeConn(err, steps.Probes...)
+	if state == ConnOK && errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		state = ConnTimeout
+	}
+	if state == ConnOK || steps.Ping == nil {
+		observer.OnGiveUp(ctx, event(err))
+		report.Finish(err)
+		return err
+	}
+	pings, x = steps.Ping(err, fields.MaxPing(), func(cnt int, i time.Duration) {
+		observer.OnPing(ctx, Event{Title: fields.Title(), Attempt: retries, Pings: cnt, Duration: i})
+	})
+	report.AddPings(pings)
+	if x != nil {
+		// Returned as is when MaxRetry is reached before another attempt.
+		err = fmt.Errorf("%w %w [ping]", err, x)
+	}
+	observer.OnRetry(ctx, event(err))
+	goto retry
+}
+
+// Savepoint runs a savepoint statement of Restart in the transaction of a backend.
+type Savepoint func(ctx context.Context, statement string) error
+
+type savepointKey struct{}
+
+// WithSavepoint returns a context whose transactions are begun with SAVEPOINT name by the backends,
+// before any other statement such as their settings, as CockroachDB requires of SAVEPOINT cockroach_restart.
+func WithSavepoint(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, savepointKey{}, name)
+}
+
+// SavepointFrom returns the name of the savepoint the transactions of ctx are begun with.
+func SavepointFrom(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(savepointKey{}).(string)
+	return name, ok
+}
+
+// Restart runs fn after SAVEPOINT name, unless ctx tells that the transaction was begun with it, see WithSavepoint.
+// On a failure for which restartable returns true, it re-runs fn after ROLLBACK TO SAVEPOINT name,
+// within the same transaction, until RELEASE SAVEPOINT name succeeds or MaxRetry restarts of the fields are exhausted.
+// Exhausting the restarts returns a Permanent error, so that the transaction is not retried anew.
+// The Doer is reset before each restart, which the observers of the fields are told of.
+func Restart(ctx context.Context, fields DoerFields, doer any, exec Savepoint, name string,
+	restartable func(error) bool, fn func(ctx context.Context) error) error {
+	if begun, _ := SavepointFrom(ctx); begun != name {
+		if err := exec(ctx, "SAVEPOINT "+name); err != nil {
+			return fmt.Errorf("%w [savepoint]", err)
+		}
+	}
+	for restarts := 0; ; restarts++ {
+		err := fn(ctx)
+		if err == nil {
+			if err = exec(ctx, "RELEASE SAVEPOINT "+name); err == nil {
+				return nil
+			}
+			err = fmt.Errorf("%w [release]", err)
+		}
+		if !restartable(err) {
+			return err
+		}
+		if restarts >= fields.MaxRetry() && fields.MaxRetry() > 0 {
+			return Permanent(fmt.Errorf("%w [restart limit]", err))
+		}
+		ObserverOf(fields).OnRetry(ctx, Event{Title: fields.Title(), Attempt: restarts, Err: err})
+		if x := exec(ctx, "ROLLBACK TO SAVEPOINT "+name); x != nil {
+			return fmt.Errorf("%w %w [restart]", err, x)
+		}
+		ResetAttempt(doer)
+	}
+}
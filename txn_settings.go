@@ -0,0 +1,45 @@
+package txn
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PostgresSettings returns the statement setting the session settings of the per-call snapshot
+// with set_config(..., true), so that they only last until the end of the transaction, and its arguments.
+// Unless set explicitly, application_name is derived from the title,
+// and statement_timeout from the remaining time before the ctx deadline.
+// The statement is empty when there is nothing to set.
+func PostgresSettings(ctx context.Context, fields DoerFields) (string, []any) {
+	settings := make(map[string]string, len(fields.Settings())+2)
+	if title := fields.Title(); title != "" {
+		settings["application_name"] = title
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if ms := time.Until(deadline).Milliseconds(); ms > 0 {
+			settings["statement_timeout"] = strconv.FormatInt(ms, 10)
+		}
+	}
+	for name, value := range fields.Settings() {
+		settings[name] = value
+	}
+	if len(settings) == 0 {
+		return "", nil
+	}
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	calls := make([]string, len(names))
+	args := make([]any, 0, 2*len(names))
+	for i, name := range names {
+		calls[i] = fmt.Sprintf("set_config($%d, $%d, true)", 2*i+1, 2*i+2)
+		args = append(args, name, settings[name])
+	}
+	return "SELECT " + strings.Join(calls, ", "), args
+}
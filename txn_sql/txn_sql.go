@@ -3,7 +3,9 @@ package txn_sql
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	//
@@ -23,6 +25,10 @@ type RawTxn interface {
 }
 
 // Doer defines the interface for SQL transaction operations.
+//
+// Stmt returns the holder last set by Module.Prepare, which is bound to the database and not to any transaction:
+// its statements run outside of the transaction of the DoFunc, and the Doer may be shared by concurrent executions.
+// DoFuncs should use StmtOf instead, which returns the holder of their own attempt, bound to its transaction.
 type Doer[Stmt any] interface {
 	txn.Doer[Options, Beginner]
 	ReadOnlySetters(title string) []txn.DoerFieldSetter
@@ -34,16 +40,21 @@ type Doer[Stmt any] interface {
 // DoerBase provides a base implementation for the Doer interface.
 type DoerBase[Stmt any] struct {
 	txn.DoerBase[Options, Beginner]
-	stmt Stmt
+	stmtMu sync.RWMutex
+	stmt   Stmt
 }
 
-// Stmt returns the statement.
+// Stmt returns the statement holder bound to the database, see Doer.
 func (do *DoerBase[S]) Stmt() S {
+	do.stmtMu.RLock()
+	defer do.stmtMu.RUnlock()
 	return do.stmt
 }
 
 // SetStmt sets the statement.
 func (do *DoerBase[S]) SetStmt(s S) {
+	do.stmtMu.Lock()
+	defer do.stmtMu.Unlock()
 	do.stmt = s
 }
 
@@ -105,12 +116,33 @@ func (w *rawTx) Rollback(context.Context) error {
 	return w.raw.Rollback()
 }
 
+// Tx returns the SQL transaction of the DoFunc's context.
+func Tx(ctx context.Context) (RawTx, error) {
+	if t, ok := txn.TxnFrom(ctx); ok {
+		if raw, ok := t.(RawTxn); ok && raw.Raw() != nil {
+			return raw.Raw(), nil
+		}
+	}
+	return nil, errors.New("no SQL transaction on current context")
+}
+
+type stmtKey struct{}
+
+// StmtOf returns the statement holder of the DoFunc's context, bound to its transaction when it implements TxBinder,
+// or else the holder set on the Doer.
+func StmtOf[Stmt any](ctx context.Context, do Doer[Stmt]) Stmt {
+	if stmt, ok := ctx.Value(stmtKey{}).(Stmt); ok {
+		return stmt
+	}
+	return do.Stmt()
+}
+
 // ExecuteOnce executes an SQL transaction.
 func ExecuteOnce[D txn.Doer[Options, Beginner]](
 	ctx context.Context, db Beginner, do D, fn txn.DoFunc[Options, Beginner, D]) (D, error) {
-	if do.Timeout() > time.Millisecond {
+	if timeout := txn.FieldsOf(ctx, do).Timeout(); timeout > time.Millisecond {
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, do.Timeout())
+		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 	return do, txn.Execute(ctx, db, do, fn)
@@ -123,17 +155,39 @@ func Ping(beginner Beginner, limit int, count txn.PingCount) (int, error) {
 	})
 }
 
-// BeginTxn begins an SQL transaction.
+// BeginTxn begins an SQL transaction, issues the savepoint of txn.WithSavepoint if any,
+// then applies the session settings of the Doer with the settings applier registered for the driver.
+// Without an applier, a Doer with settings fails with txn.ErrInvalidSetting.
+// The options of the per-call snapshot carried by ctx take precedence over opt.
 func BeginTxn(ctx context.Context, db Beginner, opt Options) (RawTxn, error) {
+	opt = txn.OptionsOf(ctx, opt)
 	var clone sql.TxOptions
 	if opt != nil {
 		clone = sql.TxOptions{Isolation: opt.Isolation, ReadOnly: opt.ReadOnly}
 	} else {
 		clone = sql.TxOptions{}
 	}
-	if raw, err := db.BeginTx(ctx, &clone); err != nil {
+	raw, err := db.BeginTx(ctx, &clone)
+	if err != nil {
+		return nil, err
+	}
+	if name, ok := txn.SavepointFrom(ctx); ok {
+		if _, err = raw.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+			err = fmt.Errorf("%w [savepoint]", err)
+		}
+	}
+	if fields, ok := txn.FieldsFrom(ctx); ok && err == nil {
+		if applier := settingsApplier(db.Driver()); applier != nil {
+			err = applier(ctx, db, raw, fields)
+		} else if len(fields.Settings()) > 0 {
+			err = errors.Join(txn.ErrInvalidSetting, fmt.Errorf("[txn_sql.BeginTxn no settings applier for %T]", db.Driver()))
+		}
+	}
+	if err != nil {
+		if x := raw.Rollback(); x != nil {
+			return nil, fmt.Errorf("%w %w [rollback]", err, x)
+		}
 		return nil, err
-	} else {
-		return &rawTx{raw: raw}, nil
 	}
+	return &rawTx{raw: raw}, nil
 }
@@ -0,0 +1,42 @@
+package txn_sql
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+
+	"github.com/struqt/txn"
+)
+
+var errorMappers struct {
+	sync.RWMutex
+	list []txn.ErrorMapper
+}
+
+// RegisterErrorMapper adds a mapper from driver errors to *txn.Error.
+// Mappers are tried in registration order and must return nil for errors of other drivers.
+func RegisterErrorMapper(mapper txn.ErrorMapper) {
+	errorMappers.Lock()
+	defer errorMappers.Unlock()
+	errorMappers.list = append(errorMappers.list, mapper)
+}
+
+// MapError maps a driver error in the chain of err to a *txn.Error with the registered mappers.
+func MapError(err error) *txn.Error {
+	errorMappers.RLock()
+	defer errorMappers.RUnlock()
+	for _, mapper := range errorMappers.list {
+		if e := mapper(err); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Probe inspects database/sql errors for lost connectivity.
+func Probe(err error) txn.ConnState {
+	if errors.Is(err, sql.ErrConnDone) {
+		return txn.ConnReset
+	}
+	return txn.ConnOK
+}
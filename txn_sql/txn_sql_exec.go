@@ -4,40 +4,64 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log/slog"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/struqt/txn"
 )
 
+// StmtHolder holds the statements prepared on the database by a Module.
+// Within a DoFunc, the holder should be taken with StmtOf, which binds it to the transaction if it implements TxBinder,
+// since the statements of an unbound holder run outside of the transaction.
 type StmtHolder interface {
 	comparable
 	io.Closer
 }
 
+// TxBinder is implemented by statement holders able to return a view of themselves bound to a transaction,
+// typically with every *sql.Stmt replaced by tx.StmtContext(ctx, stmt).
+// The bound view is handed to the DoFunc through its context, see StmtOf, and its statements are closed with the transaction.
+type TxBinder[Stmt any] interface {
+	Bind(ctx context.Context, tx RawTx) Stmt
+}
+
+// Module supplies a Doer with its statement holder.
+// Prepare returns the holder it set together with its generation, which Invalidate compares with the current one
+// to tell a holder closed or replaced during the attempt. The holder of the attempt is the one returned,
+// since the Doer may be shared by concurrent executions setting their own.
+// A Module implementing Probe(error) txn.ConnState tells lost connectivity from the errors of its driver,
+// on top of Probe.
 type Module[Stmt StmtHolder] interface {
 	Beginner() Beginner
-	Prepare(ctx context.Context, do Doer[Stmt]) error
+	Prepare(ctx context.Context, do Doer[Stmt]) (Stmt, uint64, error)
+	Invalidate(err error, gen uint64) error
 	io.Closer
 }
 
+// ModuleBase caches the statement holder behind an atomic pointer, so that a warm cache is read without locking.
+// Rebuilds are single-flight, and every rebuilt holder gets a new generation.
 type ModuleBase[Stmt StmtHolder] struct {
 	beginner   Beginner
 	cacheMaker func(context.Context, Beginner) (Stmt, error)
 	mu         sync.Mutex
-	cache      Stmt
+	cache      atomic.Pointer[cacheEntry[Stmt]]
+	gen        uint64
+	restart    atomic.Bool
+}
+
+type cacheEntry[Stmt StmtHolder] struct {
+	holder Stmt
+	gen    uint64
 }
 
 func (b *ModuleBase[Stmt]) Stmt() Stmt {
 	var empty Stmt
-	if b.cache != empty {
-		return b.cache
+	if entry := b.cache.Load(); entry != nil {
+		return entry.holder
 	}
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	return b.cache
+	return empty
 }
 
 func (b *ModuleBase[Stmt]) Init(
@@ -52,33 +76,96 @@ func (b *ModuleBase[Stmt]) Beginner() Beginner {
 	return b.beginner
 }
 
+// Close closes the cached holder, which is rebuilt by the next Prepare.
+// Transactions still using it see their statements closed, and are retried with the new holder.
 func (b *ModuleBase[Stmt]) Close() error {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	var empty Stmt
-	if b.cache != empty {
-		defer func() { b.cache = empty }()
-		return b.cache.Close()
+	if entry := b.cache.Swap(nil); entry != nil {
+		return entry.holder.Close()
 	}
 	return nil
 }
 
-func (b *ModuleBase[Stmt]) Prepare(ctx context.Context, do Doer[Stmt]) (err error) {
+// Invalidate discards the prepared statements that the error of a failed attempt shows to be unusable.
+// A holder implementing Invalidate(error) bool, such as *Statements, may invalidate a single statement.
+// Otherwise, the whole cache is closed, but only for stale statements or lost connectivity,
+// and only if it is still of generation gen, so that a holder already rebuilt is not closed again.
+// An attempt whose holder was closed or replaced meanwhile is retried with the current one if it failed on stale statements.
+// It returns err, marked Retryable when stale statements were invalidated, and joined with any Close failure.
+func (b *ModuleBase[Stmt]) Invalidate(err error, gen uint64) error {
+	return b.InvalidateWith(err, gen, Probe)
+}
+
+// InvalidateWith is Invalidate telling lost connectivity with probe, for modules knowing the errors of their driver.
+func (b *ModuleBase[Stmt]) InvalidateWith(err error, gen uint64, probe txn.Probe) error {
+	entry := b.cache.Load()
+	if entry == nil || entry.gen != gen {
+		if Stale(err) {
+			return txn.Retryable(err, 0)
+		}
+		return err
+	}
+	if holder, ok := any(entry.holder).(interface{ Invalidate(error) bool }); ok && holder.Invalidate(err) {
+		return txn.Retryable(err, 0)
+	}
+	stale := Stale(err)
+	if !stale && txn.ProbeConn(err, probe) == txn.ConnOK {
+		return err
+	}
+	if b.cache.CompareAndSwap(entry, nil) {
+		if x := entry.holder.Close(); x != nil {
+			err = fmt.Errorf("%w %w [Close]", err, x)
+		}
+	}
+	if stale {
+		return txn.Retryable(err, 0)
+	}
+	return err
+}
+
+// Prepare sets the cached holder on the Doer and returns it with its generation, building it first if the cache is cold.
+// Concurrent Prepare calls on a cold cache wait for a single build.
+func (b *ModuleBase[Stmt]) Prepare(ctx context.Context, do Doer[Stmt]) (Stmt, uint64, error) {
+	if entry := b.cache.Load(); entry != nil {
+		do.SetStmt(entry.holder)
+		return entry.holder, entry.gen, nil
+	}
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	if entry := b.cache.Load(); entry != nil {
+		do.SetStmt(entry.holder)
+		return entry.holder, entry.gen, nil
+	}
 	var empty Stmt
-	if b.cache == empty {
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, time.Second*3)
-		defer cancel()
-		b.cache, err = b.cacheMaker(ctx, b.beginner)
-		if err != nil {
-			do.SetStmt(empty)
-			return
+	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
+	defer cancel()
+	holder, err := b.cacheMaker(ctx, b.beginner)
+	if err != nil {
+		do.SetStmt(empty)
+		return empty, 0, err
+	}
+	b.gen++
+	entry := &cacheEntry[Stmt]{holder: holder, gen: b.gen}
+	b.cache.Store(entry)
+	do.SetStmt(holder)
+	return holder, entry.gen, nil
+}
+
+// bindStmt wraps the DoFunc, so that StmtOf returns the statement holder of the attempt,
+// bound to its transaction when the holder implements TxBinder.
+// The holder is carried by the context of the DoFunc, since the Doer may be shared by concurrent executions.
+func bindStmt[Stmt StmtHolder, D Doer[Stmt]](
+	holder func() Stmt, fn txn.DoFunc[Options, Beginner, D]) txn.DoFunc[Options, Beginner, D] {
+	return func(ctx context.Context, do D) error {
+		stmt := holder()
+		if binder, ok := any(stmt).(TxBinder[Stmt]); ok {
+			tx, err := Tx(ctx)
+			if err != nil {
+				return err
+			}
+			stmt = binder.Bind(ctx, tx)
 		}
+		return fn(context.WithValue(ctx, stmtKey{}, stmt), do)
 	}
-	do.SetStmt(b.cache)
-	return
 }
 
 func title[Stmt StmtHolder, D Doer[Stmt]](do D) string {
@@ -112,61 +199,37 @@ func Execute[Stmt StmtHolder, D Doer[Stmt]](
 	ctx context.Context, mod Module[Stmt], doer D,
 	fn txn.DoFunc[Options, Beginner, D], setters ...txn.DoerFieldSetter,
 ) (D, error) {
-	doer.Mutate(setters...)
-	var logger *slog.Logger
-	if v, ok := ctx.Value("logger").(*slog.Logger); ok {
-		logger = v
-	} else {
-		logger = slog.Default()
-	}
-	log := logger.With("T", doer.Title())
-	log.Debug("~", "state", "Preparing")
-	var x, err error
-	var pings = 0
-	var retries = -1
-	t0 := time.Now()
-retry:
-	retries++
-	if retries > doer.MaxRetry() && doer.MaxRetry() > 0 {
-		if err != nil {
-			log.Error(err.Error(), "retries", retries, "pings", pings)
-		}
-		return doer, err
-	}
-	err = mod.Prepare(ctx, doer)
-	if err != nil {
-		pings, x = Ping(mod.Beginner(), doer.MaxPing(), func(cnt int, i time.Duration) {
-			log.Info("Ping", "retries", retries, "pings", cnt, "interval", i)
-		})
-		connected := x == nil && pings <= 1
-		if connected && retries > 0 {
-			log.Error(err.Error(), "retries", retries, "pings", pings)
-			return doer, err
-		}
-		log.Info("", "retries", retries, "pings", pings, "err", err)
-		goto retry
+	if m, ok := mod.(interface{ CockroachRestart() bool }); ok && m.CockroachRestart() {
+		ctx = txn.WithSavepoint(ctx, "cockroach_restart")
+		fn = cockroachRestart(fn)
 	}
-	t1 := time.Now()
-	log.Debug("~", "state", "Prepared", "duration", t1.Sub(t0))
-	log.Info("+")
-	if _, err = ExecuteOnce(ctx, mod.Beginner(), doer, fn); err == nil {
-		log.Info("+", "duration", time.Now().Sub(t1))
-		return doer, nil
+	probes := []txn.Probe{Probe}
+	if m, ok := mod.(interface{ Probe(error) txn.ConnState }); ok {
+		probes = append(probes, m.Probe)
 	}
-	if x = mod.Close(); x != nil {
-		log.Error(x.Error())
-		err = fmt.Errorf("%w [exec] %w [Close]", err, x)
-	} else {
-		err = fmt.Errorf("%w [exec]", err)
-	}
-	pings, x = Ping(mod.Beginner(), doer.MaxPing(), func(cnt int, i time.Duration) {
-		log.Info("Ping", "retries", retries, "pings", cnt, "interval", i)
+	var gen uint64
+	var holder Stmt
+	fn = bindStmt[Stmt](func() Stmt { return holder }, fn)
+	err := txn.Retry(ctx, doer.Snapshot(setters...), doer, txn.Steps{
+		Prepare: func(ctx context.Context) (err error) {
+			holder, gen, err = mod.Prepare(ctx, doer)
+			return err
+		},
+		Execute: func(ctx context.Context) error {
+			_, err := ExecuteOnce(ctx, mod.Beginner(), doer, fn)
+			return err
+		},
+		Recover: func(_ context.Context, err error) error {
+			err = fmt.Errorf("%w [exec]", txn.MapError(err, MapError))
+			if txn.IsPermanent(err) {
+				return err
+			}
+			return mod.Invalidate(err, gen)
+		},
+		Probes: probes,
+		Ping: func(_ error, limit int, count txn.PingCount) (int, error) {
+			return Ping(mod.Beginner(), limit, count)
+		},
 	})
-	connected := x == nil && pings <= 1
-	if connected && retries > 0 {
-		log.Error(err.Error(), "retries", retries, "pings", pings)
-		return doer, err
-	}
-	log.Info("", "retries", retries, "pings", pings, "err", err)
-	goto retry
+	return doer, err
 }
@@ -0,0 +1,139 @@
+package txn_sql
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// LoadQueries reads named queries from the .sql files of fsys matching the glob pattern, e.g. an embed.FS.
+// Each query is introduced by an annotation line "-- name: <Name>" and runs until the next annotation.
+// Lines before the first annotation are ignored.
+func LoadQueries(fsys fs.FS, pattern string) (map[string]string, error) {
+	paths, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return nil, err
+	}
+	queries := make(map[string]string)
+	for _, path := range paths {
+		if err = loadQueries(fsys, path, queries); err != nil {
+			return nil, fmt.Errorf("%w [load %s]", err, path)
+		}
+	}
+	return queries, nil
+}
+
+func loadQueries(fsys fs.FS, path string, queries map[string]string) error {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	var name string
+	var body strings.Builder
+	flush := func() error {
+		if name == "" {
+			return nil
+		}
+		query := strings.TrimSpace(body.String())
+		if query == "" {
+			return fmt.Errorf("empty query %s", name)
+		}
+		if _, ok := queries[name]; ok {
+			return fmt.Errorf("duplicate query %s", name)
+		}
+		queries[name] = query
+		return nil
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), "-- name:"); ok {
+			if err = flush(); err != nil {
+				return err
+			}
+			name = strings.TrimSpace(v)
+			body.Reset()
+			continue
+		}
+		body.WriteString(line)
+		body.WriteByte('\n')
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// NewStatementsFS creates a registry of the named queries loaded from the .sql files of fsys.
+func NewStatementsFS(db Beginner, fsys fs.FS, pattern string) (*Statements, error) {
+	queries, err := LoadQueries(fsys, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return NewStatements(db, queries), nil
+}
+
+// Scanner is implemented by *sql.Row and *sql.Rows.
+type Scanner interface {
+	Scan(dest ...any) error
+}
+
+// Exec executes the named statement and returns the number of rows affected.
+func Exec(ctx context.Context, s *Statements, name string, args ...any) (int64, error) {
+	result, err := s.ExecContext(ctx, name, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, &StmtError{Name: name, Err: err}
+	}
+	return n, nil
+}
+
+// QueryAll runs the named query and scans every row.
+func QueryAll[T any](ctx context.Context, s *Statements, name string,
+	scan func(Scanner) (T, error), args ...any) ([]T, error) {
+	rows, err := s.QueryContext(ctx, name, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var values []T
+	for rows.Next() {
+		value, err := scan(rows)
+		if err != nil {
+			return nil, &StmtError{Name: name, Err: err}
+		}
+		values = append(values, value)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, &StmtError{Name: name, Err: err}
+	}
+	return values, nil
+}
+
+// QueryOne runs the named query and scans its first row, returning sql.ErrNoRows if there is none.
+func QueryOne[T any](ctx context.Context, s *Statements, name string,
+	scan func(Scanner) (T, error), args ...any) (T, error) {
+	var value T
+	rows, err := s.QueryContext(ctx, name, args...)
+	if err != nil {
+		return value, err
+	}
+	defer func() { _ = rows.Close() }()
+	if !rows.Next() {
+		if err = rows.Err(); err == nil {
+			err = sql.ErrNoRows
+		}
+		return value, &StmtError{Name: name, Err: err}
+	}
+	if value, err = scan(rows); err != nil {
+		return value, &StmtError{Name: name, Err: err}
+	}
+	return value, nil
+}
@@ -0,0 +1,48 @@
+package txn_sql
+
+import (
+	"context"
+	"errors"
+
+	"github.com/struqt/txn"
+)
+
+// SetCockroachRestart sets whether DoFuncs run within the CockroachDB client-side retry protocol.
+// The DoFunc then runs after SAVEPOINT cockroach_restart, and on a serialization failure (40001)
+// it is re-run after ROLLBACK TO SAVEPOINT cockroach_restart, within the same transaction,
+// until RELEASE SAVEPOINT cockroach_restart succeeds or MaxRetry restarts are exhausted.
+func (b *ModuleBase[Stmt]) SetCockroachRestart(on bool) {
+	b.restart.Store(on)
+}
+
+// CockroachRestart reports whether DoFuncs run within the CockroachDB client-side retry protocol.
+func (b *ModuleBase[Stmt]) CockroachRestart() bool {
+	return b.restart.Load()
+}
+
+// serializationFailure reports whether err is a serialization failure, either mapped by a registered mapper,
+// or carrying SQLSTATE 40001 as pgx and lib/pq errors do.
+func serializationFailure(err error) bool {
+	if errors.Is(txn.MapError(err, MapError), txn.SerializationFailure) {
+		return true
+	}
+	var state interface{ SQLState() string }
+	return errors.As(err, &state) && state.SQLState() == "40001"
+}
+
+// cockroachRestart wraps the DoFunc in the CockroachDB client-side retry protocol, run by txn.Restart.
+func cockroachRestart[D txn.Doer[Options, Beginner]](
+	fn txn.DoFunc[Options, Beginner, D]) txn.DoFunc[Options, Beginner, D] {
+	return func(ctx context.Context, do D) error {
+		tx, err := Tx(ctx)
+		if err != nil {
+			return err
+		}
+		exec := func(ctx context.Context, statement string) error {
+			_, err := tx.ExecContext(ctx, statement)
+			return err
+		}
+		return txn.Restart(ctx, txn.FieldsOf(ctx, do), do, exec, "cockroach_restart", serializationFailure,
+			func(ctx context.Context) error { return fn(ctx, do) })
+	}
+}
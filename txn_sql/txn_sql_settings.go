@@ -0,0 +1,53 @@
+package txn_sql
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/struqt/txn"
+)
+
+// SettingsApplier applies the session settings of the per-call snapshot right after BEGIN of tx on db.
+type SettingsApplier func(ctx context.Context, db Beginner, tx RawTx, fields txn.DoerFields) error
+
+var settingsAppliers struct {
+	sync.RWMutex
+	byDriver map[reflect.Type]SettingsApplier
+}
+
+// RegisterSettingsApplier sets the settings applier of the driver's type, run right after every BEGIN.
+// Transactions on drivers without an applier fail for Doers with settings.
+// PostgreSQL drivers, such as pgx's stdlib or lib/pq, take ApplyPostgresSettings, e.g.
+//
+//	txn_sql.RegisterSettingsApplier(stdlib.GetDefaultDriver(), txn_sql.ApplyPostgresSettings)
+//	txn_sql.RegisterSettingsApplier(&pq.Driver{}, txn_sql.ApplyPostgresSettings)
+func RegisterSettingsApplier(drv driver.Driver, applier SettingsApplier) {
+	settingsAppliers.Lock()
+	defer settingsAppliers.Unlock()
+	if settingsAppliers.byDriver == nil {
+		settingsAppliers.byDriver = make(map[reflect.Type]SettingsApplier)
+	}
+	settingsAppliers.byDriver[reflect.TypeOf(drv)] = applier
+}
+
+// settingsApplier returns the settings applier of the driver's type, nil if none is registered.
+func settingsApplier(drv driver.Driver) SettingsApplier {
+	settingsAppliers.RLock()
+	defer settingsAppliers.RUnlock()
+	return settingsAppliers.byDriver[reflect.TypeOf(drv)]
+}
+
+// ApplyPostgresSettings applies the settings of txn.PostgresSettings in a single statement.
+func ApplyPostgresSettings(ctx context.Context, _ Beginner, tx RawTx, fields txn.DoerFields) error {
+	query, args := txn.PostgresSettings(ctx, fields)
+	if query == "" {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("%w [settings]", err)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package txn_sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// StmtError attributes an error to the named statement that caused it.
+type StmtError struct {
+	Name string
+	Err  error
+}
+
+func (e *StmtError) Error() string {
+	return fmt.Sprintf("%v [stmt %s]", e.Err, e.Name)
+}
+
+func (e *StmtError) Unwrap() error { return e.Err }
+
+var ErrUnknownStmt = errors.New("unknown statement")
+
+// Stale reports whether err shows a prepared statement that can no longer be used,
+// such as a closed *sql.Stmt, or a statement the server no longer knows or must re-plan.
+func Stale(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "sql: statement is closed") ||
+		strings.Contains(msg, "cached plan must not change result type") ||
+		(strings.Contains(msg, "prepared statement") && strings.Contains(msg, "does not exist")) ||
+		strings.Contains(msg, "Unknown prepared statement handler")
+}
+
+// Statements is a registry of named prepared statements, each prepared on first use
+// and invalidated on its own, so that one stale statement is re-prepared without closing the others.
+// It can serve as a statement holder, and its Bind view runs the statements in a transaction.
+type Statements struct {
+	reg *registry
+	tx  RawTx
+}
+
+// registry holds the entries of a Statements, which are fixed at creation.
+type registry struct {
+	db      Beginner
+	entries map[string]*entry
+}
+
+// entry lazily prepares a single statement, so that preparing one does not block the others.
+type entry struct {
+	query string
+	mutex sync.Mutex
+	stmt  *sql.Stmt
+}
+
+// NewStatements creates a registry of the named queries on the database.
+func NewStatements(db Beginner, queries map[string]string) *Statements {
+	reg := &registry{db: db, entries: make(map[string]*entry, len(queries))}
+	for name, query := range queries {
+		reg.entries[name] = &entry{query: query}
+	}
+	return &Statements{reg: reg}
+}
+
+// Bind returns a view of the registry running its statements in the transaction.
+func (s *Statements) Bind(_ context.Context, tx RawTx) *Statements {
+	return &Statements{reg: s.reg, tx: tx}
+}
+
+// Names returns the names of the registered statements.
+func (s *Statements) Names() []string {
+	names := make([]string, 0, len(s.reg.entries))
+	for name := range s.reg.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check prepares every registered statement, returning the failures as joined *StmtError values.
+// It is meant to validate all the statements at startup.
+func (s *Statements) Check(ctx context.Context) error {
+	var errs []error
+	for _, name := range s.Names() {
+		if _, err := s.reg.prepare(ctx, name); err != nil {
+			errs = append(errs, &StmtError{Name: name, Err: err})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Stmt returns the named statement, preparing it if needed.
+// On a bound view, the statement is specific to the transaction.
+func (s *Statements) Stmt(ctx context.Context, name string) (*sql.Stmt, error) {
+	stmt, err := s.reg.prepare(ctx, name)
+	if err != nil {
+		return nil, &StmtError{Name: name, Err: err}
+	}
+	if s.tx != nil {
+		return s.tx.StmtContext(ctx, stmt), nil
+	}
+	return stmt, nil
+}
+
+// ExecContext executes the named statement.
+func (s *Statements) ExecContext(ctx context.Context, name string, args ...any) (sql.Result, error) {
+	stmt, err := s.Stmt(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	result, err := stmt.ExecContext(ctx, args...)
+	if err != nil {
+		return nil, &StmtError{Name: name, Err: err}
+	}
+	return result, nil
+}
+
+// QueryContext executes the named query.
+func (s *Statements) QueryContext(ctx context.Context, name string, args ...any) (*sql.Rows, error) {
+	stmt, err := s.Stmt(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return nil, &StmtError{Name: name, Err: err}
+	}
+	return rows, nil
+}
+
+// Invalidate closes the statement err is attributed to, if err shows it to be stale,
+// so that it is re-prepared on next use. It reports whether a statement was invalidated.
+func (s *Statements) Invalidate(err error) bool {
+	var stmtErr *StmtError
+	if !errors.As(err, &stmtErr) || !Stale(err) {
+		return false
+	}
+	e, ok := s.reg.entries[stmtErr.Name]
+	if ok {
+		_ = e.close()
+	}
+	return ok
+}
+
+// Close closes all the prepared statements, which are re-prepared on next use.
+func (s *Statements) Close() error {
+	var errs []error
+	for name, e := range s.reg.entries {
+		if err := e.close(); err != nil {
+			errs = append(errs, &StmtError{Name: name, Err: err})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *registry) prepare(ctx context.Context, name string) (*sql.Stmt, error) {
+	e, ok := r.entries[name]
+	if !ok {
+		return nil, ErrUnknownStmt
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.stmt == nil {
+		stmt, err := r.db.PrepareContext(ctx, e.query)
+		if err != nil {
+			return nil, err
+		}
+		e.stmt = stmt
+	}
+	return e.stmt, nil
+}
+
+func (e *entry) close() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.stmt == nil {
+		return nil
+	}
+	defer func() { e.stmt = nil }()
+	return e.stmt.Close()
+}
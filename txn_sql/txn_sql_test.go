@@ -0,0 +1,574 @@
+package txn_sql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+
+	"github.com/struqt/txn"
+)
+
+type testDoer struct {
+	DoerBase[any]
+}
+
+type stmtDoer struct {
+	DoerBase[*Statements]
+}
+
+func (do *stmtDoer) BeginTxn(ctx context.Context, db Beginner) (txn.Txn, error) {
+	return BeginTxn(ctx, db, nil)
+}
+
+// sharedDoer is a stmtDoer whose holder is replaced by a concurrent execution as soon as it is set.
+type sharedDoer struct {
+	stmtDoer
+	other *Statements
+}
+
+func (do *sharedDoer) SetStmt(*Statements) { do.stmtDoer.SetStmt(do.other) }
+
+// fakeDriver is a database/sql driver recording the statements it runs,
+// failing those for which fail returns an error, and answering queries with the single column rows of their values.
+// Preparing a query is checked with fail as "PREPARE <query>".
+type fakeDriver struct {
+	mutex    sync.Mutex
+	queries  []string
+	prepared int
+	fail     func(query string) error
+	rows     map[string][]driver.Value
+}
+
+type fakeConn struct{ d *fakeDriver }
+
+type fakeStmt struct {
+	c     *fakeConn
+	query string
+}
+
+type fakeTx struct{}
+
+func (d *fakeDriver) Connect(context.Context) (driver.Conn, error) { return &fakeConn{d: d}, nil }
+func (d *fakeDriver) Driver() driver.Driver                        { return d }
+func (d *fakeDriver) Open(string) (driver.Conn, error)             { return &fakeConn{d: d}, nil }
+
+func (d *fakeDriver) db(t *testing.T) *sql.DB {
+	db := sql.OpenDB(d)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func (d *fakeDriver) run(query string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.queries = append(d.queries, query)
+	if d.fail != nil {
+		return d.fail(query)
+	}
+	return nil
+}
+
+func (d *fakeDriver) count(query string) int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	n := 0
+	for _, q := range d.queries {
+		if q == query {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	c.d.mutex.Lock()
+	defer c.d.mutex.Unlock()
+	if c.d.fail != nil {
+		if err := c.d.fail("PREPARE " + query); err != nil {
+			return nil, err
+		}
+	}
+	c.d.prepared++
+	return &fakeStmt{c: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+func (c *fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return fakeTx{}, c.d.run("BEGIN")
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	if err := c.d.run(query); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	if err := s.c.d.run(s.query); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	if err := s.c.d.run(s.query); err != nil {
+		return nil, err
+	}
+	s.c.d.mutex.Lock()
+	defer s.c.d.mutex.Unlock()
+	return &fakeRows{values: s.c.d.rows[s.query]}, nil
+}
+
+type fakeRows struct{ values []driver.Value }
+
+func (r *fakeRows) Columns() []string { return []string{"v"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0], r.values = r.values[0], r.values[1:]
+	return nil
+}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+func TestStmtOf(t *testing.T) {
+	d := &fakeDriver{}
+	db := d.db(t)
+	mod := &ModuleBase[*Statements]{}
+	mod.Init(db, func(context.Context, Beginner) (*Statements, error) {
+		return NewStatements(db, map[string]string{"touch": "UPDATE t SET n = n + 1"}), nil
+	})
+	doer := &stmtDoer{}
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ExecuteRw(context.Background(), Module[*Statements](mod), doer,
+				func(ctx context.Context, do *stmtDoer) error {
+					tx, err := Tx(ctx)
+					if err != nil {
+						return err
+					}
+					stmt := StmtOf[*Statements](ctx, do)
+					if stmt.tx != tx {
+						return fmt.Errorf("statements bound to %p, not to the transaction %p", stmt.tx, tx)
+					}
+					_, err = Exec(ctx, stmt, "touch")
+					return err
+				})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := d.count("UPDATE t SET n = n + 1"); n != cap(errs) {
+		t.Errorf("Expected %d updates, got %d", cap(errs), n)
+	}
+	if stmt := StmtOf[*Statements](context.Background(), doer); stmt != mod.Stmt() || stmt.tx != nil {
+		t.Errorf("Expected the unbound holder outside of a transaction, got %+v", stmt)
+	}
+
+	shared := &sharedDoer{other: NewStatements(db, nil)}
+	_, err := ExecuteRw(context.Background(), Module[*Statements](mod), shared,
+		func(ctx context.Context, do *sharedDoer) error {
+			if stmt := StmtOf[*Statements](ctx, do); stmt.reg != mod.Stmt().reg {
+				return errors.New("statements of another execution")
+			}
+			return nil
+		})
+	if err != nil {
+		t.Errorf("Expected the holder prepared for the attempt, got %v", err)
+	}
+}
+
+// pgDriver is a fakeDriver of its own type, taking ApplyPostgresSettings.
+type pgDriver struct{ *fakeDriver }
+
+func (d pgDriver) Driver() driver.Driver { return d }
+
+func TestSettingsApplier(t *testing.T) {
+	ctx := context.Background()
+	doer := &testDoer{}
+	begin := func(db *sql.DB, setters ...txn.DoerFieldSetter) error {
+		raw, err := BeginTxn(txn.WithFields(ctx, doer.Snapshot(setters...)), db, nil)
+		if err == nil {
+			err = raw.Rollback(ctx)
+		}
+		return err
+	}
+	settings := txn.WithSettings(map[string]string{"lock_timeout": "1s"})
+
+	d := &fakeDriver{}
+	db := d.db(t)
+	if err := begin(db, txn.WithTitle("TxnRw`test")); err != nil || len(d.queries) != 1 {
+		t.Errorf("Expected nothing applied without an applier, got %v and %v", d.queries, err)
+	}
+	if err := begin(db, settings); !errors.Is(err, txn.ErrInvalidSetting) || len(d.queries) != 2 {
+		t.Errorf("Expected settings without an applier rejected, got %v and %v", d.queries, err)
+	}
+
+	pg := pgDriver{&fakeDriver{}}
+	RegisterSettingsApplier(pg, ApplyPostgresSettings)
+	db = sql.OpenDB(pg)
+	t.Cleanup(func() { _ = db.Close() })
+	if err := begin(db, txn.WithTitle("TxnRw`test"), settings); err != nil ||
+		pg.count("SELECT set_config($1, $2, true), set_config($3, $4, true)") != 1 {
+		t.Errorf("Expected the settings applied by the registered applier, got %v and %v", pg.queries, err)
+	}
+}
+
+type fakeHolder struct {
+	closed atomic.Int32
+}
+
+func (h *fakeHolder) Close() error {
+	h.closed.Add(1)
+	return nil
+}
+
+func TestInvalidate(t *testing.T) {
+	var built []*fakeHolder
+	var mutex sync.Mutex
+	mod := &ModuleBase[*fakeHolder]{}
+	mod.Init(nil, func(context.Context, Beginner) (*fakeHolder, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		built = append(built, &fakeHolder{})
+		return built[len(built)-1], nil
+	})
+	doer := &DoerBase[*fakeHolder]{}
+	ctx := context.Background()
+	stale := errors.New("sql: statement is closed")
+	failure := errors.New("duplicate key")
+	retryable := func(err error) bool {
+		_, ok := txn.RetryAfter(err)
+		return ok
+	}
+
+	holder, gen, err := mod.Prepare(ctx, doer)
+	if err != nil || holder != built[0] || doer.Stmt() != built[0] {
+		t.Fatalf("Expected the built holder handed to the Doer, got %v", err)
+	}
+	if err = mod.Invalidate(failure, gen); err != failure || built[0].closed.Load() != 0 {
+		t.Errorf("Expected an unrelated failure returned as is, got %v", err)
+	}
+	if err = mod.Invalidate(stale, gen); !retryable(err) || built[0].closed.Load() != 1 || mod.Stmt() != nil {
+		t.Errorf("Expected the holder closed and the attempt retried, got %v", err)
+	}
+	if err = mod.Invalidate(stale, gen); !retryable(err) || built[0].closed.Load() != 1 {
+		t.Errorf("Expected a stale attempt retried without closing the holder again, got %v", err)
+	}
+	if holder, _, err = mod.Prepare(ctx, doer); err != nil || holder != built[1] || doer.Stmt() != built[1] {
+		t.Fatalf("Expected the holder rebuilt, got %v", err)
+	}
+	if err = mod.Invalidate(failure, gen); err != failure || built[1].closed.Load() != 0 {
+		t.Errorf("Expected an unrelated failure on a replaced holder returned as is, got %v", err)
+	}
+	if _, gen, _ = mod.Prepare(ctx, doer); mod.Invalidate(sql.ErrConnDone, gen) != sql.ErrConnDone ||
+		built[1].closed.Load() != 1 {
+		t.Errorf("Expected the holder closed on lost connectivity")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			do := &DoerBase[*fakeHolder]{}
+			_, gen, err := mod.Prepare(ctx, do)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_ = mod.Invalidate(stale, gen)
+		}()
+	}
+	wg.Wait()
+	mutex.Lock()
+	defer mutex.Unlock()
+	for i, holder := range built {
+		if n := holder.closed.Load(); n != 1 {
+			t.Errorf("Expected holder %d closed once, got %d", i, n)
+		}
+	}
+}
+
+func TestLoadQueries(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql/users.sql": {Data: []byte("-- users of the shop\n" +
+			"-- name: GetUser\nSELECT name\n  FROM users\n WHERE id = $1;\n\n" +
+			"  -- name: CountUsers  \nSELECT count(*) FROM users\n")},
+		"sql/orders.sql": {Data: []byte("-- name: AddOrder\nINSERT INTO orders (user_id) VALUES ($1)\n")},
+		"sql/notes.txt":  {Data: []byte("-- name: Ignored\nSELECT 1\n")},
+	}
+	queries, err := LoadQueries(fsys, "sql/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"GetUser":    "SELECT name\n  FROM users\n WHERE id = $1;",
+		"CountUsers": "SELECT count(*) FROM users",
+		"AddOrder":   "INSERT INTO orders (user_id) VALUES ($1)",
+	}
+	if !reflect.DeepEqual(queries, expected) {
+		t.Errorf("Expected %q, got %q", expected, queries)
+	}
+
+	for data, message := range map[string]string{
+		"-- name: A\nSELECT 1\n-- name: A\nSELECT 2\n": "duplicate query A [load bad.sql]",
+		"-- name: A\nSELECT 1\n-- name: B\n\n":         "empty query B [load bad.sql]",
+	} {
+		fsys = fstest.MapFS{"bad.sql": {Data: []byte(data)}}
+		if _, err = LoadQueries(fsys, "*.sql"); err == nil || err.Error() != message {
+			t.Errorf("Expected %q, got %v", message, err)
+		}
+	}
+	fsys = fstest.MapFS{"a.sql": {Data: []byte("-- name: A\nSELECT 1\n")}, "b.sql": {Data: []byte("-- name: A\nSELECT 2\n")}}
+	if _, err = NewStatementsFS(nil, fsys, "*.sql"); err == nil || err.Error() != "duplicate query A [load b.sql]" {
+		t.Errorf("Expected a query duplicated across files rejected, got %v", err)
+	}
+	if _, err = LoadQueries(fsys, "[*.sql"); err == nil {
+		t.Error("Expected a malformed pattern rejected")
+	}
+}
+
+func TestStatements(t *testing.T) {
+	fsys := fstest.MapFS{"q.sql": {Data: []byte("-- name: Names\nSELECT name FROM users\n" +
+		"-- name: Nobody\nSELECT name FROM users WHERE false\n" +
+		"-- name: Touch\nUPDATE users SET n = n + 1\n" +
+		"-- name: Missing\nSELECT name FROM missing\n")}}
+	missing := errors.New(`relation "missing" does not exist`)
+	d := &fakeDriver{
+		fail: func(query string) error {
+			if query == "PREPARE SELECT name FROM missing" {
+				return missing
+			}
+			return nil
+		},
+		rows: map[string][]driver.Value{"SELECT name FROM users": {"ann", "bob"}},
+	}
+	db := d.db(t)
+	statements, err := NewStatementsFS(db, fsys, "*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := statements.Names(); fmt.Sprint(names) != "[Missing Names Nobody Touch]" {
+		t.Errorf("Expected the statements named by their annotations, got %v", names)
+	}
+	ctx := context.Background()
+
+	err = statements.Check(ctx)
+	var stmtErr *StmtError
+	if !errors.As(err, &stmtErr) || stmtErr.Name != "Missing" || !errors.Is(err, missing) {
+		t.Fatalf("Expected the statement failing to prepare reported by name, got %v", err)
+	}
+	if d.prepared != 3 {
+		t.Errorf("Expected the other statements prepared, got %d", d.prepared)
+	}
+
+	scan := func(row Scanner) (name string, err error) {
+		err = row.Scan(&name)
+		return name, err
+	}
+	names, err := QueryAll(ctx, statements, "Names", scan)
+	if err != nil || fmt.Sprint(names) != "[ann bob]" {
+		t.Errorf("Expected every row scanned, got %v, %v", names, err)
+	}
+	name, err := QueryOne(ctx, statements, "Names", scan)
+	if err != nil || name != "ann" {
+		t.Errorf("Expected the first row scanned, got %q, %v", name, err)
+	}
+	if _, err = QueryOne(ctx, statements, "Nobody", scan); !errors.Is(err, sql.ErrNoRows) ||
+		!errors.As(err, &stmtErr) || stmtErr.Name != "Nobody" {
+		t.Errorf("Expected no rows for Nobody, got %v", err)
+	}
+	if n, err := Exec(ctx, statements, "Touch"); err != nil || n != 1 {
+		t.Errorf("Expected a row affected, got %d, %v", n, err)
+	}
+	if _, err = Exec(ctx, statements, "Missing"); !errors.Is(err, missing) {
+		t.Errorf("Expected the statement failing to prepare on use, got %v", err)
+	}
+	if _, err = QueryAll(ctx, statements, "Unknown", scan); !errors.Is(err, ErrUnknownStmt) ||
+		!errors.As(err, &stmtErr) || stmtErr.Name != "Unknown" {
+		t.Errorf("Expected an unknown statement, got %v", err)
+	}
+	if d.prepared != 3 {
+		t.Errorf("Expected the statements prepared once, got %d", d.prepared)
+	}
+}
+
+func TestStale(t *testing.T) {
+	for _, c := range []struct {
+		err   error
+		stale bool
+	}{
+		{nil, false},
+		{errors.New("sql: statement is closed"), true},
+		{fmt.Errorf("%w [exec]", errors.New("ERROR: cached plan must not change result type (SQLSTATE 0A000)")), true},
+		{errors.New(`pq: prepared statement "stmt_1" does not exist`), true},
+		{errors.New("Error 1243 (HY000): Unknown prepared statement handler (7) given to mysqld_stmt_execute"), true},
+		{errors.New(`pq: relation "t" does not exist`), false},
+		{errors.New("duplicate key"), false},
+	} {
+		if Stale(c.err) != c.stale {
+			t.Errorf("Expected Stale(%v) to be %v", c.err, c.stale)
+		}
+	}
+}
+
+func TestStatementsInvalidate(t *testing.T) {
+	stale := errors.New(`pq: prepared statement "a" does not exist`)
+	failures := 0
+	d := &fakeDriver{fail: func(query string) error {
+		if query == "UPDATE a" && failures > 0 {
+			failures--
+			return stale
+		}
+		return nil
+	}}
+	db := d.db(t)
+	statements := NewStatements(db, map[string]string{"a": "UPDATE a", "b": "UPDATE b"})
+	mod := &ModuleBase[*Statements]{}
+	mod.Init(db, func(context.Context, Beginner) (*Statements, error) { return statements, nil })
+	ctx := context.Background()
+	if err := statements.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	a, b := statements.reg.entries["a"].stmt, statements.reg.entries["b"].stmt
+
+	failures = 1
+	attempts := 0
+	_, err := ExecuteRw(ctx, Module[*Statements](mod), &stmtDoer{}, func(ctx context.Context, do *stmtDoer) error {
+		attempts++
+		stmt := StmtOf[*Statements](ctx, do)
+		if _, err := Exec(ctx, stmt, "b"); err != nil {
+			return err
+		}
+		_, err := Exec(ctx, stmt, "a")
+		return err
+	})
+	if err != nil || attempts != 2 {
+		t.Fatalf("Expected the stale statement retried once, got %v after %d attempts", err, attempts)
+	}
+	if mod.Stmt() != statements {
+		t.Error("Expected the registry kept by the module")
+	}
+	if statements.reg.entries["a"].stmt == a {
+		t.Error("Expected the stale statement re-prepared")
+	}
+	if statements.reg.entries["b"].stmt != b {
+		t.Error("Expected the other statement kept prepared")
+	}
+
+	for _, err := range []error{
+		&StmtError{Name: "b", Err: errors.New("duplicate key")},
+		&StmtError{Name: "c", Err: stale},
+		stale,
+	} {
+		if statements.Invalidate(err) {
+			t.Errorf("Expected %v not to invalidate any statement", err)
+		}
+	}
+	if statements.reg.entries["b"].stmt != b {
+		t.Error("Expected the statement kept prepared on an unrelated error")
+	}
+	if !statements.Invalidate(fmt.Errorf("%w [exec]", &StmtError{Name: "b", Err: stale})) ||
+		statements.reg.entries["b"].stmt != nil {
+		t.Error("Expected the statement invalidated on its own stale error")
+	}
+}
+
+type sqlStateError string
+
+func (e sqlStateError) Error() string    { return "SQLSTATE " + string(e) }
+func (e sqlStateError) SQLState() string { return string(e) }
+
+func TestCockroachRestart(t *testing.T) {
+	const update = "UPDATE t SET n = n + 1"
+	failures := 0
+	d := &fakeDriver{fail: func(query string) error {
+		if query == update && failures > 0 {
+			failures--
+			return sqlStateError("40001")
+		}
+		return nil
+	}}
+	run := func(fails int, setters ...txn.DoerFieldSetter) ([]string, error) {
+		d.mutex.Lock()
+		d.queries, failures = nil, fails
+		d.mutex.Unlock()
+		db := d.db(t)
+		mod := &ModuleBase[*Statements]{}
+		mod.Init(db, func(context.Context, Beginner) (*Statements, error) { return NewStatements(db, nil), nil })
+		mod.SetCockroachRestart(true)
+		_, err := ExecuteRw(context.Background(), Module[*Statements](mod), &stmtDoer{},
+			func(ctx context.Context, do *stmtDoer) error {
+				tx, err := Tx(ctx)
+				if err != nil {
+					return err
+				}
+				_, err = tx.ExecContext(ctx, update)
+				return err
+			}, setters...)
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		return d.queries, err
+	}
+
+	queries, err := run(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[BEGIN SAVEPOINT cockroach_restart " +
+		"UPDATE t SET n = n + 1 ROLLBACK TO SAVEPOINT cockroach_restart " +
+		"UPDATE t SET n = n + 1 ROLLBACK TO SAVEPOINT cockroach_restart " +
+		"UPDATE t SET n = n + 1 RELEASE SAVEPOINT cockroach_restart]"
+	if fmt.Sprint(queries) != expected {
+		t.Errorf("Expected the DoFunc restarted within the transaction, got %v", queries)
+	}
+	queries, err = run(5, txn.WithMaxRetry(1))
+	if !txn.IsPermanent(err) || d.count("BEGIN") != 1 || len(queries) != 5 {
+		t.Errorf("Expected a permanent error within a single transaction, got %v after %v", err, queries)
+	}
+
+	// CockroachDB requires SAVEPOINT cockroach_restart to be the first statement of the transaction.
+	pg := pgDriver{&fakeDriver{}}
+	RegisterSettingsApplier(pg, ApplyPostgresSettings)
+	db := sql.OpenDB(pg)
+	t.Cleanup(func() { _ = db.Close() })
+	mod := &ModuleBase[*Statements]{}
+	mod.Init(db, func(context.Context, Beginner) (*Statements, error) { return NewStatements(db, nil), nil })
+	mod.SetCockroachRestart(true)
+	_, err = ExecuteRw(context.Background(), Module[*Statements](mod), &stmtDoer{},
+		func(context.Context, *stmtDoer) error { return nil }, txn.WithTitle("restart"))
+	expected = "[BEGIN SAVEPOINT cockroach_restart SELECT set_config($1, $2, true), set_config($3, $4, true) " +
+		"RELEASE SAVEPOINT cockroach_restart]"
+	if err != nil || fmt.Sprint(pg.queries) != expected {
+		t.Errorf("Expected the savepoint issued before the settings, got %v and %v", pg.queries, err)
+	}
+}
@@ -0,0 +1,188 @@
+package txn_sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/struqt/txn"
+	"github.com/struqt/txn/txn_sql"
+	"modernc.org/sqlite"
+)
+
+func init() {
+	txn_sql.RegisterSettingsApplier(&sqlite.Driver{}, ApplySettings)
+}
+
+// Lock is the locking mode of read-write transactions.
+type Lock string
+
+const (
+	LockImmediate Lock = "immediate" // BEGIN IMMEDIATE takes the write lock up front.
+	LockExclusive Lock = "exclusive" // BEGIN EXCLUSIVE also keeps readers out, except in WAL mode.
+)
+
+// Options configures the databases opened by NewModule.
+type Options struct {
+	WAL         bool          // Sets journal_mode to WAL, letting readers run alongside the writer.
+	BusyTimeout time.Duration // Sets busy_timeout, how long SQLite waits for a lock before SQLITE_BUSY.
+	Lock        Lock          // Locking mode of read-write transactions, LockImmediate by default.
+	Backoff     time.Duration // Base delay before retrying a busy transaction, 10ms by default.
+	MaxReaders  int           // Maximum open connections of the reader, unlimited by default.
+	Pragmas     []string      // Further pragmas run on every connection, e.g. "foreign_keys(1)".
+}
+
+// Module holds a reader and a writer database on the same file, each with its own statement cache.
+// The writer has a single connection, so that writes queue in-process instead of contending for the lock.
+type Module[Stmt txn_sql.StmtHolder] struct {
+	reader *module[Stmt]
+	writer *module[Stmt]
+}
+
+// module marks busy errors as retryable with a jittered backoff.
+type module[Stmt txn_sql.StmtHolder] struct {
+	txn_sql.ModuleBase[Stmt]
+	db      *sql.DB
+	backoff time.Duration
+}
+
+func (m *module[Stmt]) Invalidate(err error, gen uint64) error {
+	if Busy(err) {
+		return txn.Retryable(err, m.backoff+time.Duration(rand.Int63n(int64(m.backoff))))
+	}
+	return m.ModuleBase.Invalidate(err, gen)
+}
+
+// NewModule opens the SQLite database file at path, with the statement holders built by maker.
+func NewModule[Stmt txn_sql.StmtHolder](
+	path string, opt Options, maker func(context.Context, txn_sql.Beginner) (Stmt, error),
+) (*Module[Stmt], error) {
+	if opt.Lock == "" {
+		opt.Lock = LockImmediate
+	}
+	if opt.Lock != LockImmediate && opt.Lock != LockExclusive {
+		return nil, errors.Join(fmt.Errorf("unknown lock %q", opt.Lock), errors.New("[txn_sqlite.NewModule]"))
+	}
+	if opt.Backoff <= 0 {
+		opt.Backoff = 10 * time.Millisecond
+	}
+	writer, err := sql.Open("sqlite", dsn(path, opt, false))
+	if err != nil {
+		return nil, fmt.Errorf("%w [open writer]", err)
+	}
+	writer.SetMaxOpenConns(1)
+	reader, err := sql.Open("sqlite", dsn(path, opt, true))
+	if err != nil {
+		_ = writer.Close()
+		return nil, fmt.Errorf("%w [open reader]", err)
+	}
+	if opt.MaxReaders > 0 {
+		reader.SetMaxOpenConns(opt.MaxReaders)
+	}
+	mod := &Module[Stmt]{
+		reader: &module[Stmt]{db: reader, backoff: opt.Backoff},
+		writer: &module[Stmt]{db: writer, backoff: opt.Backoff},
+	}
+	mod.reader.Init(reader, maker)
+	mod.writer.Init(writer, maker)
+	return mod, nil
+}
+
+// dsn builds the connection string of the reader or the writer.
+// The writer begins read-write transactions with the locking mode, the reader is query_only.
+func dsn(path string, opt Options, reader bool) string {
+	query := url.Values{}
+	if opt.BusyTimeout > 0 {
+		query.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", opt.BusyTimeout.Milliseconds()))
+	}
+	if opt.WAL {
+		query.Add("_pragma", "journal_mode(WAL)")
+	}
+	for _, pragma := range opt.Pragmas {
+		query.Add("_pragma", pragma)
+	}
+	if reader {
+		query.Add("_pragma", "query_only(1)")
+	} else {
+		query.Set("_txlock", string(opt.Lock))
+	}
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + query.Encode()
+}
+
+// Reader returns the module of read-only transactions.
+func (m *Module[Stmt]) Reader() txn_sql.Module[Stmt] {
+	return m.reader
+}
+
+// Writer returns the module of read-write transactions.
+func (m *Module[Stmt]) Writer() txn_sql.Module[Stmt] {
+	return m.writer
+}
+
+// Close closes the statement caches, then both databases.
+func (m *Module[Stmt]) Close() error {
+	return errors.Join(m.reader.Close(), m.writer.Close(), m.reader.db.Close(), m.writer.db.Close())
+}
+
+// ExecuteRw executes fn in a read-write transaction on the writer, begun with the locking mode of the module.
+func ExecuteRw[Stmt txn_sql.StmtHolder, D txn_sql.Doer[Stmt]](
+	ctx context.Context, mod *Module[Stmt], do D,
+	fn txn.DoFunc[txn_sql.Options, txn_sql.Beginner, D], setters ...txn.DoerFieldSetter,
+) (D, error) {
+	return txn_sql.ExecuteRw(ctx, mod.Writer(), do, fn, setters...)
+}
+
+// ExecuteRo executes fn in a deferred read-only transaction on the reader.
+func ExecuteRo[Stmt txn_sql.StmtHolder, D txn_sql.Doer[Stmt]](
+	ctx context.Context, mod *Module[Stmt], do D,
+	fn txn.DoFunc[txn_sql.Options, txn_sql.Beginner, D], setters ...txn.DoerFieldSetter,
+) (D, error) {
+	return txn_sql.ExecuteRo(ctx, mod.Reader(), do, fn, setters...)
+}
+
+// pragmas are the pragmas run by ApplySettings, with the check of their values.
+// Only pragmas reset at the end of the transaction are allowed, since the others would outlive it
+// on its pooled connection; these belong to Options.Pragmas, run on every connection.
+var pragmas = map[string]func(value string) bool{
+	"defer_foreign_keys": boolean,
+}
+
+func boolean(value string) bool {
+	switch strings.ToLower(value) {
+	case "0", "1", "on", "off", "true", "false", "yes", "no":
+		return true
+	}
+	return false
+}
+
+// ApplySettings runs the settings of the per-call snapshot as pragmas sorted by name, e.g. "defer_foreign_keys" to "1".
+// Settings other than the pragmas scoped to the transaction, or with invalid values, fail with txn.ErrInvalidSetting
+// before any pragma runs.
+// It is registered as the settings applier of the SQLite driver.
+func ApplySettings(ctx context.Context, _ txn_sql.Beginner, tx txn_sql.RawTx, fields txn.DoerFields) error {
+	settings := fields.Settings()
+	names := make([]string, 0, len(settings))
+	for name, value := range settings {
+		if valid, ok := pragmas[name]; !ok || !valid(value) {
+			return errors.Join(txn.ErrInvalidSetting, fmt.Errorf("[txn_sqlite.ApplySettings %q]", name))
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA %s = %s", name, settings[name])); err != nil {
+			return fmt.Errorf("%w [pragma %s]", err, name)
+		}
+	}
+	return nil
+}
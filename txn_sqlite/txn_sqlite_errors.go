@@ -0,0 +1,53 @@
+package txn_sqlite
+
+import (
+	"errors"
+
+	"github.com/struqt/txn"
+	"github.com/struqt/txn/txn_sql"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+func init() {
+	txn_sql.RegisterErrorMapper(MapError)
+}
+
+var errorKinds = map[int]txn.ErrorKind{
+	sqlite3.SQLITE_CONSTRAINT_UNIQUE:     txn.UniqueViolation,
+	sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY: txn.UniqueViolation,
+	sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY: txn.ForeignKeyViolation,
+	sqlite3.SQLITE_CONSTRAINT_CHECK:      txn.CheckViolation,
+	sqlite3.SQLITE_CONSTRAINT_NOTNULL:    txn.NotNullViolation,
+	sqlite3.SQLITE_BUSY:                  txn.LockTimeout,
+	sqlite3.SQLITE_LOCKED:                txn.LockTimeout,
+	sqlite3.SQLITE_INTERRUPT:             txn.StatementTimeout,
+}
+
+// MapError maps a *sqlite.Error in the chain of err to a *txn.Error.
+// It is registered with txn_sql.RegisterErrorMapper.
+func MapError(err error) *txn.Error {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return nil
+	}
+	kind, ok := errorKinds[sqliteErr.Code()]
+	if !ok {
+		kind, ok = errorKinds[sqliteErr.Code()&0xff]
+	}
+	if !ok {
+		return nil
+	}
+	return &txn.Error{Kind: kind, Err: err}
+}
+
+// Busy reports whether err is SQLITE_BUSY or SQLITE_LOCKED, including their extended codes,
+// as returned once busy_timeout has elapsed without getting the lock.
+func Busy(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
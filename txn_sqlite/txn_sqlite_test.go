@@ -0,0 +1,203 @@
+package txn_sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/struqt/txn"
+	"github.com/struqt/txn/txn_sql"
+)
+
+type nopStmt struct{}
+
+func (*nopStmt) Close() error { return nil }
+
+type testDoer struct {
+	txn_sql.DoerBase[*nopStmt]
+}
+
+func (do *testDoer) BeginTxn(ctx context.Context, db txn_sql.Beginner) (txn.Txn, error) {
+	return txn_sql.BeginTxn(ctx, db, nil)
+}
+
+type doFunc = txn.DoFunc[txn_sql.Options, txn_sql.Beginner, *testDoer]
+
+type retryObserver struct {
+	txn.ObserverBase
+	retries []txn.Event
+}
+
+func (o *retryObserver) OnRetry(_ context.Context, e txn.Event) {
+	o.retries = append(o.retries, e)
+}
+
+// newModule opens a module on a new database file with table t, and another database on the same file.
+func newModule(t *testing.T, opt Options) (*Module[*nopStmt], *sql.DB) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	mod, err := NewModule(path, opt, func(context.Context, txn_sql.Beginner) (*nopStmt, error) {
+		return &nopStmt{}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = mod.Close() })
+	if _, err = ExecuteRw(context.Background(), mod, &testDoer{},
+		exec("CREATE TABLE t (id INTEGER PRIMARY KEY, n INTEGER)")); err != nil {
+		t.Fatal(err)
+	}
+	other, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = other.Close() })
+	return mod, other
+}
+
+func exec(query string, args ...any) doFunc {
+	return func(ctx context.Context, do *testDoer) error {
+		tx, err := txn_sql.Tx(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, query, args...)
+		return err
+	}
+}
+
+func TestBeginImmediate(t *testing.T) {
+	mod, other := newModule(t, Options{WAL: true})
+	held, release, done := make(chan struct{}), make(chan struct{}), make(chan error)
+	go func() {
+		_, err := ExecuteRw(context.Background(), mod, &testDoer{}, func(context.Context, *testDoer) error {
+			close(held)
+			<-release
+			return nil
+		})
+		done <- err
+	}()
+	<-held
+	// A deferred BEGIN would not take any lock before the first write.
+	if _, err := other.Exec("INSERT INTO t VALUES (1, 1)"); !Busy(err) {
+		t.Errorf("Expected the write lock taken by BEGIN IMMEDIATE, got %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Exec("INSERT INTO t VALUES (1, 1)"); err != nil {
+		t.Errorf("Expected the write lock released, got %v", err)
+	}
+}
+
+func TestBusy(t *testing.T) {
+	const backoff = 20 * time.Millisecond
+	mod, other := newModule(t, Options{WAL: true, Backoff: backoff})
+	lock, err := other.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = lock.Exec("INSERT INTO t VALUES (1, 1)"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	observer := &retryObserver{}
+	t0 := time.Now()
+	_, err = ExecuteRw(ctx, mod, &testDoer{}, exec("INSERT INTO t VALUES (2, 2)"),
+		txn.WithMaxRetry(2), txn.WithObservers(observer))
+	if !Busy(err) || !errors.Is(err, txn.LockTimeout) {
+		t.Errorf("Expected a busy error mapped to LockTimeout, got %v", err)
+	}
+	// OnRetry is also called after the last attempt, before the retry limit is checked.
+	if len(observer.retries) != 3 {
+		t.Errorf("Expected 3 busy attempts, got %d", len(observer.retries))
+	}
+	if elapsed := time.Since(t0); elapsed < 3*backoff {
+		t.Errorf("Expected retries after a backoff of at least %v, got %v in total", backoff, elapsed)
+	}
+
+	observer = &retryObserver{}
+	time.AfterFunc(100*time.Millisecond, func() { _ = lock.Rollback() })
+	if _, err = ExecuteRw(ctx, mod, &testDoer{}, exec("INSERT INTO t VALUES (2, 2)"),
+		txn.WithMaxRetry(50), txn.WithObservers(observer)); err != nil {
+		t.Fatal(err)
+	}
+	if len(observer.retries) == 0 || !Busy(observer.retries[0].Err) {
+		t.Errorf("Expected busy attempts retried until the lock was released, got %v", observer.retries)
+	}
+}
+
+func TestMapError(t *testing.T) {
+	mod, _ := newModule(t, Options{})
+	ctx := context.Background()
+	if _, err := ExecuteRw(ctx, mod, &testDoer{}, exec("INSERT INTO t VALUES (1, 1)")); err != nil {
+		t.Fatal(err)
+	}
+	observer := &retryObserver{}
+	_, err := ExecuteRw(ctx, mod, &testDoer{}, exec("INSERT INTO t VALUES (1, 2)"), txn.WithObservers(observer))
+	if !errors.Is(err, txn.UniqueViolation) || len(observer.retries) != 0 {
+		t.Errorf("Expected a unique violation given up on, got %v after %d retries", err, len(observer.retries))
+	}
+	if _, err = ExecuteRw(ctx, mod, &testDoer{}, exec("INSERT INTO t VALUES (NULL, 1)")); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ExecuteRw(ctx, mod, &testDoer{}, exec("CREATE TABLE c (n INTEGER NOT NULL CHECK (n > 0))"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ExecuteRw(ctx, mod, &testDoer{}, exec("INSERT INTO c VALUES (0)")); !errors.Is(err, txn.CheckViolation) {
+		t.Errorf("Expected a check violation, got %v", err)
+	}
+	if _, err = ExecuteRw(ctx, mod, &testDoer{}, exec("INSERT INTO c VALUES (NULL)")); !errors.Is(err, txn.NotNullViolation) {
+		t.Errorf("Expected a not-null violation, got %v", err)
+	}
+}
+
+func TestApplySettings(t *testing.T) {
+	mod, _ := newModule(t, Options{})
+	ctx := context.Background()
+	deferred := func(value *int) doFunc {
+		return func(ctx context.Context, do *testDoer) error {
+			tx, err := txn_sql.Tx(ctx)
+			if err != nil {
+				return err
+			}
+			return tx.QueryRowContext(ctx, "PRAGMA defer_foreign_keys").Scan(value)
+		}
+	}
+
+	var value int
+	_, err := ExecuteRw(ctx, mod, &testDoer{}, deferred(&value),
+		txn.WithSettings(map[string]string{"defer_foreign_keys": "ON"}))
+	if err != nil || value != 1 {
+		t.Fatalf("Expected defer_foreign_keys set in the transaction, got %d, %v", value, err)
+	}
+	// The writer has a single connection, so the next transaction runs on the same one.
+	if _, err = ExecuteRw(ctx, mod, &testDoer{}, deferred(&value)); err != nil || value != 0 {
+		t.Errorf("Expected defer_foreign_keys reset with the transaction, got %d, %v", value, err)
+	}
+
+	for _, settings := range []map[string]string{
+		{"journal_mode": "DELETE"},
+		{"foreign_keys": "0"},
+		{"defer_foreign_keys": "1; DROP TABLE t"},
+		{"defer_foreign_keys = 1; DROP TABLE t; --": "1"},
+	} {
+		called := false
+		_, err = ExecuteRw(ctx, mod, &testDoer{}, func(context.Context, *testDoer) error {
+			called = true
+			return nil
+		}, txn.WithSettings(settings))
+		if !errors.Is(err, txn.ErrInvalidSetting) || called {
+			t.Errorf("Expected %v rejected before the DoFunc, got %v", settings, err)
+		}
+	}
+	if _, err = ExecuteRw(ctx, mod, &testDoer{}, exec("INSERT INTO t VALUES (1, 1)")); err != nil {
+		t.Errorf("Expected table t kept, got %v", err)
+	}
+}
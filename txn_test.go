@@ -2,8 +2,15 @@ package txn
 
 import (
 	"context"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestPing(t *testing.T) {
@@ -55,3 +62,361 @@ func TestPing(t *testing.T) {
 	})
 
 }
+
+type fakeTxn struct {
+	commit error
+}
+
+func (t *fakeTxn) Commit(context.Context) error   { return t.commit }
+func (t *fakeTxn) Rollback(context.Context) error { return nil }
+
+type fakeDoer struct {
+	DoerBase[any, any]
+	txn *fakeTxn
+}
+
+func (do *fakeDoer) BeginTxn(context.Context, any) (Txn, error) {
+	return do.txn, nil
+}
+
+func TestReport(t *testing.T) {
+	t.Run("phases of committed attempt", func(t *testing.T) {
+		var report Report
+		doer := &fakeDoer{txn: &fakeTxn{}}
+		doer.Mutate(WithReport(&report))
+		report.Reset("test")
+		report.NextAttempt()
+		err := Execute(context.Background(), nil, doer, DoFunc[any, any, *fakeDoer](func(context.Context, *fakeDoer) error {
+			time.Sleep(10 * time.Millisecond)
+			return nil
+		}))
+		report.Finish(err)
+		if !report.Succeeded() || len(report.Attempts) != 1 {
+			t.Fatalf("Expected one committed attempt, got %+v", report)
+		}
+		if a := report.Attempts[0]; a.Do < 10*time.Millisecond || a.Rollback != 0 {
+			t.Errorf("Unexpected phase durations %+v", a)
+		}
+	})
+
+	t.Run("errors of failed attempt", func(t *testing.T) {
+		var report Report
+		doer := &fakeDoer{txn: &fakeTxn{}}
+		doer.Mutate(WithReport(&report))
+		report.Reset("test")
+		report.NextAttempt()
+		failed := errors.New("failed")
+		err := Execute(context.Background(), nil, doer, DoFunc[any, any, *fakeDoer](func(context.Context, *fakeDoer) error {
+			return failed
+		}))
+		report.Fail(err)
+		report.Finish(err)
+		if report.Succeeded() || len(report.Errors) != 1 || !errors.Is(report.Attempts[0].Err, failed) {
+			t.Errorf("Expected one failed attempt, got %+v", report)
+		}
+		if _, err = json.Marshal(&report); err != nil {
+			t.Errorf("Expected report to marshal, got %v", err)
+		}
+	})
+
+	t.Run("nil report", func(t *testing.T) {
+		var report *Report
+		report.Reset("test")
+		report.NextAttempt()
+		report.Track(PhaseDo, time.Now())
+		report.Fail(errors.New("failed"))
+		report.Finish(nil)
+		if report.Succeeded() {
+			t.Errorf("Expected nil report not to succeed")
+		}
+	})
+}
+
+type recordingObserver struct {
+	ObserverBase
+	events []string
+}
+
+func (o *recordingObserver) OnBegin(context.Context, Event)  { o.events = append(o.events, "begin") }
+func (o *recordingObserver) OnCommit(context.Context, Event) { o.events = append(o.events, "commit") }
+func (o *recordingObserver) OnRollback(context.Context, Event) {
+	o.events = append(o.events, "rollback")
+}
+func (o *recordingObserver) OnPanic(context.Context, Event) { o.events = append(o.events, "panic") }
+
+func TestObserver(t *testing.T) {
+	run := func(fn func()) []string {
+		observer := &recordingObserver{}
+		doer := &fakeDoer{txn: &fakeTxn{}}
+		doer.Mutate(WithTitle("test"), WithObservers(observer))
+		_ = Execute(context.Background(), nil, doer, DoFunc[any, any, *fakeDoer](func(context.Context, *fakeDoer) error {
+			fn()
+			return nil
+		}))
+		return observer.events
+	}
+	if events := run(func() {}); fmt.Sprint(events) != "[begin commit]" {
+		t.Errorf("Expected begin and commit events, got %v", events)
+	}
+	if events := run(func() { panic("boom") }); fmt.Sprint(events) != "[begin panic rollback]" {
+		t.Errorf("Expected begin, panic and rollback events, got %v", events)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	doer := &fakeDoer{txn: &fakeTxn{}}
+	doer.Mutate(WithTitle("base"), WithMaxRetry(1))
+	fields := doer.Snapshot(WithTitle("call"))
+	if doer.Title() != "base" || fields.Title() != "call" || fields.MaxRetry() != 1 {
+		t.Fatalf("Expected snapshot to leave the Doer unchanged, got %q and %q", doer.Title(), fields.Title())
+	}
+	var title string
+	observer := &recordingObserver{}
+	ctx := WithFields(context.Background(), doer.Snapshot(WithTitle("call"), WithObservers(observer)))
+	_ = Execute(ctx, nil, doer, DoFunc[any, any, *fakeDoer](func(ctx context.Context, do *fakeDoer) error {
+		title = FieldsOf(ctx, do).Title()
+		return nil
+	}))
+	if title != "call" || len(observer.events) != 2 {
+		t.Errorf("Expected Execute to use the snapshot carried by ctx, got %q and %v", title, observer.events)
+	}
+	base, call := &fakeTxn{}, &fakeTxn{}
+	if OptionsOf(context.Background(), base) != base {
+		t.Errorf("Expected the options passed without a snapshot")
+	}
+	ctx = WithFields(context.Background(), doer.Snapshot(WithOptions(call)))
+	if OptionsOf(ctx, base) != call || OptionsOf(ctx, "other") != "other" {
+		t.Errorf("Expected the options of the snapshot to take precedence")
+	}
+}
+
+func TestErrorMarkers(t *testing.T) {
+	cause := errors.New("insufficient balance")
+	err := fmt.Errorf("%w [txn do]", Permanent(cause))
+	if !IsPermanent(err) || !errors.Is(err, cause) {
+		t.Errorf("Expected a permanent error wrapping the cause, got %v", err)
+	}
+	if _, ok := RetryAfter(err); ok {
+		t.Errorf("Expected a permanent error not to be retryable")
+	}
+	err = fmt.Errorf("%w [txn do]", Retryable(cause, time.Second))
+	if after, ok := RetryAfter(err); !ok || after != time.Second || IsPermanent(err) {
+		t.Errorf("Expected a retryable error after 1s, got %v and %v", after, ok)
+	}
+	if Permanent(nil) != nil || Retryable(nil, 0) != nil {
+		t.Errorf("Expected nil errors to stay nil")
+	}
+}
+
+func TestMapError(t *testing.T) {
+	cause := errors.New("duplicate key value violates unique constraint")
+	mapper := func(err error) *Error {
+		if errors.Is(err, cause) {
+			return &Error{Kind: UniqueViolation, Constraint: "users_email_key", Err: err}
+		}
+		return nil
+	}
+	err := MapError(fmt.Errorf("%w [txn do]", cause), mapper)
+	var e *Error
+	if !errors.Is(err, UniqueViolation) || errors.Is(err, Deadlock) || !errors.As(err, &e) {
+		t.Fatalf("Expected a unique violation, got %v", err)
+	}
+	if e.Constraint != "users_email_key" || !errors.Is(err, cause) || err.Error() != cause.Error()+" [txn do]" {
+		t.Errorf("Expected the mapped error to keep the chain, got %v", err)
+	}
+	if other := errors.New("other"); MapError(other, mapper) != other {
+		t.Errorf("Expected unrecognised errors to be returned as is")
+	}
+}
+
+type timeoutError struct{}
+
+func (*timeoutError) Error() string   { return "i/o timeout" }
+func (*timeoutError) Timeout() bool   { return true }
+func (*timeoutError) Temporary() bool { return true }
+
+func TestProbeConn(t *testing.T) {
+	refused := func(error) ConnState { return ConnRefused }
+	for _, c := range []struct {
+		err    error
+		probes []Probe
+		want   ConnState
+	}{
+		{nil, nil, ConnOK},
+		{errors.New("insufficient balance"), nil, ConnOK},
+		{fmt.Errorf("%w [txn do]", driver.ErrBadConn), nil, ConnReset},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, nil, ConnRefused},
+		{&net.OpError{Op: "read", Err: &timeoutError{}}, nil, ConnTimeout},
+		{context.DeadlineExceeded, nil, ConnOK},
+		{fmt.Errorf("%w [txn do]", context.Canceled), nil, ConnOK},
+		{errors.New("backend specific"), []Probe{refused}, ConnRefused},
+	} {
+		if got := ProbeConn(c.err, c.probes...); got != c.want {
+			t.Errorf("Expected %v for %v, got %v", c.want, c.err, got)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var ping error
+	ctx := context.Background()
+	run := func(max int, errs ...error) (int, int, error) {
+		var attempts, pings int
+		doer := &fakeDoer{txn: &fakeTxn{}}
+		err := Retry(ctx, doer.Snapshot(WithMaxRetry(max)), doer, Steps{
+			Execute: func(ctx context.Context) error {
+				if AttemptFrom(ctx) != attempts {
+					t.Errorf("Expected attempt %d on ctx, got %d", attempts, AttemptFrom(ctx))
+				}
+				attempts++
+				if len(errs) < attempts {
+					return nil
+				}
+				return errs[attempts-1]
+			},
+			Ping: func(_ error, _ int, _ PingCount) (int, error) {
+				pings++
+				return 1, ping
+			},
+		})
+		return attempts, pings, err
+	}
+	failed := errors.New("failed")
+	if attempts, pings, err := run(3, Retryable(failed, time.Millisecond), &Error{Kind: Deadlock}); err != nil ||
+		attempts != 3 || pings != 0 {
+		t.Errorf("Expected retryable and transient errors to be retried, got %d, %d and %v", attempts, pings, err)
+	}
+	if attempts, _, err := run(3, Permanent(failed)); !errors.Is(err, failed) || attempts != 1 {
+		t.Errorf("Expected a permanent error to give up, got %d and %v", attempts, err)
+	}
+	if attempts, _, err := run(3, failed); !errors.Is(err, failed) || attempts != 1 {
+		t.Errorf("Expected an error with connectivity ok to give up, got %d and %v", attempts, err)
+	}
+	if attempts, pings, err := run(3, driver.ErrBadConn); err != nil || attempts != 2 || pings != 1 {
+		t.Errorf("Expected lost connectivity to ping and retry, got %d, %d and %v", attempts, pings, err)
+	}
+	if attempts, _, err := run(1, driver.ErrBadConn, driver.ErrBadConn, driver.ErrBadConn); !errors.Is(err, driver.ErrBadConn) ||
+		attempts != 2 {
+		t.Errorf("Expected to give up after MaxRetry, got %d and %v", attempts, err)
+	}
+	deadlock := &Error{Kind: Deadlock}
+	t0 := time.Now()
+	if attempts, _, err := run(0, deadlock, deadlock, deadlock, deadlock); err != nil || attempts != 5 {
+		t.Errorf("Expected transient errors to be retried without MaxRetry, got %d and %v", attempts, err)
+	}
+	if elapsed := time.Since(t0); elapsed < 75*time.Millisecond {
+		t.Errorf("Expected transient retries to back off 5+10+20+40ms at least, got %v", elapsed)
+	}
+
+	stalled := fmt.Errorf("%w [txn do]", context.DeadlineExceeded)
+	if attempts, pings, err := run(3, stalled); err != nil || attempts != 2 || pings != 1 {
+		t.Errorf("Expected an attempt timed out on its own deadline to ping and retry, got %d, %d and %v",
+			attempts, pings, err)
+	}
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, 0)
+	defer cancel()
+	if attempts, pings, err := run(3, stalled); !errors.Is(err, context.DeadlineExceeded) || attempts != 1 || pings != 0 {
+		t.Errorf("Expected the caller's expired deadline to give up, got %d, %d and %v", attempts, pings, err)
+	}
+	ctx = context.Background()
+
+	ping = errors.New("unreachable")
+	if _, _, err := run(1, driver.ErrBadConn, driver.ErrBadConn); !errors.Is(err, driver.ErrBadConn) || !errors.Is(err, ping) {
+		t.Errorf("Expected the ping failure joined to the error, got %v", err)
+	}
+}
+
+type resettingDoer struct {
+	fakeDoer
+	rows []int
+}
+
+func (do *resettingDoer) ResetAttempt() {
+	do.rows = nil
+}
+
+func TestResetAttempt(t *testing.T) {
+	doer := &resettingDoer{fakeDoer: fakeDoer{txn: &fakeTxn{}}}
+	doer.rows = []int{-1}
+	fn := DoFunc[any, any, *resettingDoer](func(ctx context.Context, do *resettingDoer) error {
+		do.rows = append(do.rows, AttemptFrom(ctx))
+		if AttemptFrom(ctx) == 0 {
+			return Retryable(errors.New("failed once"), 0)
+		}
+		return nil
+	})
+	err := Retry(context.Background(), doer.Snapshot(WithMaxRetry(2)), doer, Steps{
+		Execute: func(ctx context.Context) error {
+			return Execute(ctx, nil, doer, fn)
+		},
+	})
+	if err != nil || fmt.Sprint(doer.rows) != "[1]" {
+		t.Errorf("Expected the retried attempt to start from a reset Doer, got %v and %v", doer.rows, err)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	conflict := errors.New("restart transaction")
+	ctx := context.Background()
+	run := func(max int, errs ...error) ([]string, *resettingDoer, error) {
+		var statements []string
+		exec := func(_ context.Context, statement string) error {
+			statements = append(statements, statement)
+			return nil
+		}
+		doer := &resettingDoer{fakeDoer: fakeDoer{txn: &fakeTxn{}}}
+		attempts := 0
+		err := Restart(ctx, doer.Snapshot(WithMaxRetry(max)), doer, exec, "sp",
+			func(err error) bool { return errors.Is(err, conflict) },
+			func(context.Context) error {
+				doer.rows = append(doer.rows, attempts)
+				if attempts++; attempts > len(errs) {
+					return nil
+				}
+				return errs[attempts-1]
+			})
+		return statements, doer, err
+	}
+
+	statements, doer, err := run(3, conflict, conflict)
+	if err != nil || fmt.Sprint(doer.rows) != "[2]" {
+		t.Errorf("Expected the restarts to start from a reset Doer, got %v and %v", doer.rows, err)
+	}
+	if fmt.Sprint(statements) != "[SAVEPOINT sp ROLLBACK TO SAVEPOINT sp ROLLBACK TO SAVEPOINT sp RELEASE SAVEPOINT sp]" {
+		t.Errorf("Unexpected statements %v", statements)
+	}
+	failed := errors.New("failed")
+	if statements, _, err = run(3, failed); err != failed || len(statements) != 1 {
+		t.Errorf("Expected a failure not to restart, got %v after %v", err, statements)
+	}
+	if _, doer, err = run(1, conflict, conflict); !errors.Is(err, conflict) || !IsPermanent(err) || len(doer.rows) != 1 {
+		t.Errorf("Expected a permanent error after MaxRetry restarts, got %v after %d runs", err, len(doer.rows))
+	}
+	ctx = WithSavepoint(ctx, "sp")
+	if statements, _, err = run(3, conflict); err != nil ||
+		fmt.Sprint(statements) != "[ROLLBACK TO SAVEPOINT sp RELEASE SAVEPOINT sp]" {
+		t.Errorf("Expected the savepoint the transaction was begun with not issued again, got %v and %v", statements, err)
+	}
+}
+
+func TestPostgresSettings(t *testing.T) {
+	doer := &fakeDoer{}
+	if query, args := PostgresSettings(context.Background(), doer.Snapshot()); query != "" || args != nil {
+		t.Errorf("Expected nothing to set, got %q and %v", query, args)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	fields := doer.Snapshot(WithTitle("TxnRw`test"),
+		WithSettings(map[string]string{"lock_timeout": "1s", "application_name": "api"}))
+	query, args := PostgresSettings(ctx, fields)
+	if query != "SELECT set_config($1, $2, true), set_config($3, $4, true), set_config($5, $6, true)" {
+		t.Fatalf("Unexpected query %s", query)
+	}
+	if fmt.Sprint(args[:5]) != "[application_name api lock_timeout 1s statement_timeout]" {
+		t.Errorf("Expected the settings sorted by name with explicit ones taking precedence, got %v", args)
+	}
+	if ms, err := strconv.Atoi(args[5].(string)); err != nil || ms <= 1000 || ms > 2000 {
+		t.Errorf("Expected statement_timeout derived from the deadline, got %v", args[5])
+	}
+}